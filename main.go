@@ -1,15 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
+	"net/url"
 	"nuggan"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/davidbyttow/govips/pkg/vips"
 )
@@ -28,11 +31,17 @@ var (
 
 	encodeUrl = flag.String("encode-url", "", "An image URL to be encoded according the 'groupedBaseUrls' setting in the server configuration (e.g. http://image/url/to/be/encoded/according/server-conf)")
 
+	encodeTransformation = flag.String("encode-transformation", "0/0/-/-/-/-/-", "Transformation path (':cropX/:cropY/:cropWidth/:cropHeight/:resizeWidth/:resizeHeight/:compressionLevel', 'p/:preset' or 'img?w=320&fmt=webp') for the URL to be encoded, signed if 'secrets' are configured")
+
+	encodeTtl = flag.Duration("encode-ttl", 0, "If 'secrets' are configured, duration (e.g. '24h') after which the signed URL expires (default: never)")
+
 	inputUrl = flag.String("in", "", "Url to load")
 	output   = flag.String("out", "output", "File to write out")
 
 	width  = flag.Int("w", 640, "Viewport width")
 	height = flag.Int("h", -1, "Viewport height")
+
+	upscale = flag.Float64("upscale", 1, "Maximum enlargement factor, if the viewport is larger than the image (default: no enlargement)")
 )
 
 func main() {
@@ -45,7 +54,9 @@ func main() {
 
 		fmt.Fprintf(os.Stderr, "\nEncode an URL according a server configuration:\n\n\t%s -server-config server.conf -encode-url 'http://an/image/url'\n", os.Args[0])
 
-		fmt.Fprintf(os.Stderr, "\nScale down an image locally:\n\n\t%s -in 'http://input/image/url' -out '/path/for/output/image' -w scale_down_width_int -h scale_down_height_int\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nEncode & sign an URL for a given transformation:\n\n\t%s -server-config server.conf -encode-url 'http://an/image/url' -encode-transformation '0/0/-/-/128/-/-' [-encode-ttl 24h]\n", os.Args[0])
+
+		fmt.Fprintf(os.Stderr, "\nScale down an image locally:\n\n\t%s -in 'http://input/image/url' -out '/path/for/output/image' -w scale_down_width_int -h scale_down_height_int [-upscale 2] [-server-config server.conf]\n", os.Args[0])
 
 		fmt.Fprintf(os.Stderr, "\nDetailed options:\n\n")
 		flag.PrintDefaults()
@@ -128,7 +139,41 @@ func main() {
 		encode := nuggan.EncodeMediaUrl(conf)
 		repr := encode(*encodeUrl)
 
-		log.Printf("\nEncode '%s':\n\n\t%s\n\n\te.g. http://localhost:8080/%s/0/0/-/-/-/-/-/%s\n\n", *encodeUrl, repr, conf.RoutePrefix, repr)
+		path := fmt.Sprintf("%s/%s", *encodeTransformation, repr)
+
+		if q := strings.SplitN(*encodeTransformation, "?", 2); q[0] == "img" {
+			// Query route, with normalized query
+			path = fmt.Sprintf("img/%s", repr)
+
+			if len(q) == 2 {
+				query, err := url.ParseQuery(q[1])
+
+				if err != nil {
+					fmt.Fprintf(os.Stderr,
+						"Invalid transformation query: %s\n",
+						err.Error())
+
+					flag.Usage()
+
+					return
+				}
+
+				path = fmt.Sprintf("%s?%s", path, query.Encode())
+			}
+		}
+
+		if len(conf.Secrets) > 0 && *encodeTtl > 0 {
+			sign := nuggan.SignExpiringPath(conf)
+			expires := time.Now().Add(*encodeTtl)
+
+			path = fmt.Sprintf("%s/%s", sign(path, expires), path)
+		} else if len(conf.Secrets) > 0 {
+			sign := nuggan.SignPath(conf)
+
+			path = fmt.Sprintf("%s/%s", sign(path), path)
+		}
+
+		log.Printf("\nEncode '%s':\n\n\t%s\n\n\te.g. http://localhost:8080%s/%s\n\n", *encodeUrl, repr, conf.RoutePrefix, path)
 
 		return
 	}
@@ -143,7 +188,46 @@ func main() {
 
 	// ---
 
-	err := cliScaleDown(*inputUrl, *output, *width, *height)
+	// Origin settings from the server configuration, if specified
+	originConf := nuggan.OriginConfig{}
+	explicitConfig := false
+
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "server-config" {
+			explicitConfig = true
+		}
+	})
+
+	if explicitConfig {
+		f, err := os.Open(*serverConfig)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr,
+				"Fails to open configuration file: %s\n",
+				err.Error())
+
+			flag.Usage()
+
+			return
+		}
+
+		conf, err := nuggan.LoadConfig(f)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr,
+				"Fails to load configuration: %s\n",
+				err.Error())
+
+			flag.Usage()
+
+			return
+		}
+
+		originConf = conf.Origin
+	}
+
+	err := cliScaleDown(
+		originConf, *inputUrl, *output, *width, *height, *upscale)
 
 	if err != nil {
 		log.Printf(err.Error())
@@ -156,16 +240,18 @@ func main() {
 }
 
 func cliScaleDown(
+	originConf nuggan.OriginConfig,
 	inputUrl string,
 	output string,
 	width int,
 	height int,
+	maxScale float64,
 ) error {
 	var reader io.Reader = nil
 
 	if !strings.HasPrefix(inputUrl, "file://") {
 		// Fetch image from public HTTP URL
-		imgResp, err := http.Get(inputUrl)
+		data, err := nuggan.FetchMedia(originConf, inputUrl)
 
 		if err != nil {
 			return errors.New(
@@ -175,17 +261,7 @@ func cliScaleDown(
 
 		}
 
-		defer imgResp.Body.Close()
-
-		if imgResp.StatusCode != 200 {
-			return errors.New(
-				fmt.Sprintf(
-					"Fails to fetch image from %s: %s",
-					inputUrl, imgResp.Status))
-
-		}
-
-		reader = imgResp.Body
+		reader = bytes.NewReader(data)
 	} else {
 		file, err := os.Open((inputUrl)[7:])
 
@@ -220,17 +296,23 @@ func cliScaleDown(
 
 	// ---
 
-	image, err := vips.LoadImage(reader)
+	opts := nuggan.TransformOptions{
+		ResizeWidth: width,
+		Upscale:     maxScale > 1,
+		Limits:      nuggan.LimitsConfig{MaxUpscale: maxScale},
+	}
 
-	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot load image: %v", err))
+	if height > 0 {
+		opts.ResizeHeight = height
 	}
 
-	// ---
+	_, err = nuggan.Transform(context.Background(), reader, opts, writer)
 
-	defer image.Close()
+	if err != nil {
+		return errors.New(fmt.Sprintf("Cannot transform image: %v", err))
+	}
 
-	nuggan.ScaleDown(image, width, height, -1, writer)
+	// ---
 
 	vips.Shutdown()
 
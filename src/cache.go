@@ -0,0 +1,127 @@
+package nuggan
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Transformed image, with its response headers
+type cachedImage struct {
+	Header map[string]string
+	Body   []byte
+}
+
+// Response headers kept along with a transformed image
+var cachedHeaders = []string{
+	"Etag",
+	"Last-Modified",
+	"Content-Type",
+	"Content-Disposition",
+	"Content-DPR",
+	"Vary",
+}
+
+type imageCache interface {
+	Get(key string) (*cachedImage, bool)
+	Set(key string, image *cachedImage)
+}
+
+// ---
+
+type memoryEntry struct {
+	key     string
+	image   *cachedImage
+	size    int64
+	expires time.Time
+}
+
+// In-memory LRU cache, bounded by the total size of the cached images.
+type memoryCache struct {
+	mutex    sync.Mutex
+	maxBytes int64
+	ttl      time.Duration
+	size     int64
+	entries  *list.List // most recently used first
+	index    map[string]*list.Element
+}
+
+// - maxBytes: Maximum total size of the cached entries
+// - ttl: Time to live of each entry (or 0 if no expiry)
+func newMemoryCache(maxBytes int64, ttl time.Duration) *memoryCache {
+	return &memoryCache{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		entries:  list.New(),
+		index:    make(map[string]*list.Element),
+	}
+}
+
+func (c *memoryCache) Get(key string) (*cachedImage, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elmt, ok := c.index[key]
+
+	if !ok {
+		return nil, false
+	}
+
+	entry := elmt.Value.(*memoryEntry)
+
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.remove(elmt)
+
+		return nil, false
+	}
+
+	c.entries.MoveToFront(elmt)
+
+	return entry.image, true
+}
+
+func (c *memoryCache) Set(key string, image *cachedImage) {
+	size := entrySize(key, image)
+
+	if size > c.maxBytes {
+		return // would evict everything else
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elmt, ok := c.index[key]; ok {
+		c.remove(elmt)
+	}
+
+	entry := &memoryEntry{key: key, image: image, size: size}
+
+	if c.ttl > 0 {
+		entry.expires = time.Now().Add(c.ttl)
+	}
+
+	c.index[key] = c.entries.PushFront(entry)
+	c.size += size
+
+	for c.size > c.maxBytes {
+		c.remove(c.entries.Back())
+	}
+}
+
+func (c *memoryCache) remove(elmt *list.Element) {
+	entry := c.entries.Remove(elmt).(*memoryEntry)
+
+	delete(c.index, entry.key)
+
+	c.size -= entry.size
+}
+
+func entrySize(key string, image *cachedImage) int64 {
+	size := len(key) + len(image.Body)
+
+	for k, v := range image.Header {
+		size += len(k) + len(v)
+	}
+
+	return int64(size)
+}
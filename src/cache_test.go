@@ -0,0 +1,150 @@
+package nuggan
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testImage(size int) *cachedImage {
+	return &cachedImage{
+		Header: map[string]string{},
+		Body:   make([]byte, size),
+	}
+}
+
+func TestMemoryCacheGetSet(t *testing.T) {
+	cache := newMemoryCache(1024, 0)
+
+	if _, ok := cache.Get("a"); ok {
+		t.Error("Cache must be initially empty")
+	}
+
+	img := testImage(10)
+
+	cache.Set("a", img)
+
+	got, ok := cache.Get("a")
+
+	if !ok || got != img {
+		t.Errorf("Cached image expected: %v", got)
+	}
+}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	cache := newMemoryCache(100, 0)
+
+	cache.Set("a", testImage(40))
+	cache.Set("b", testImage(40))
+
+	cache.Get("a") // 'b' becomes least recently used
+
+	cache.Set("c", testImage(40))
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("Least recently used entry must be evicted")
+	}
+
+	if _, ok := cache.Get("a"); !ok {
+		t.Error("Recently used entry must be kept")
+	}
+
+	if _, ok := cache.Get("c"); !ok {
+		t.Error("Last entry must be kept")
+	}
+
+	if cache.size > 100 {
+		t.Errorf("Cache size exceeded: %d", cache.size)
+	}
+}
+
+func TestMemoryCacheTooLarge(t *testing.T) {
+	cache := newMemoryCache(100, 0)
+
+	cache.Set("a", testImage(10))
+	cache.Set("b", testImage(200))
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("Entry larger than the cache must be ignored")
+	}
+
+	if _, ok := cache.Get("a"); !ok {
+		t.Error("Entry must be kept")
+	}
+}
+
+func TestMemoryCacheReplace(t *testing.T) {
+	cache := newMemoryCache(100, 0)
+
+	cache.Set("a", testImage(40))
+	cache.Set("a", testImage(50))
+
+	if cache.size != 51 {
+		t.Errorf("Replaced entry must be released: %d", cache.size)
+	}
+}
+
+func TestMemoryCacheTtl(t *testing.T) {
+	cache := newMemoryCache(100, time.Millisecond)
+
+	cache.Set("a", testImage(10))
+
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := cache.Get("a"); ok {
+		t.Error("Expired entry must not be returned")
+	}
+
+	if cache.size != 0 {
+		t.Errorf("Expired entry must be released: %d", cache.size)
+	}
+}
+
+func TestCachedVaryHeader(t *testing.T) {
+	origin := httptest.NewServer(http.FileServer(http.Dir("../test")))
+
+	defer origin.Close()
+
+	conf, err := LoadConfig(strings.NewReader(`
+groupedBaseUrls = [ [ "` + origin.URL + `" ] ]
+acceptFormats = [ "webp" ]
+memoryCacheSize = 10485760
+`))
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	serve := Service(conf)
+	ref := EncodeMediaUrl(conf)(origin.URL + "/image1.jpg")
+
+	for _, expectedCache := range []string{"MISS", "HIT"} {
+		headers := http.Header{}
+		status := 200
+
+		serve(&ImageRequest{
+			Path:   "/optimg/0/0/-/-/-/-/-/" + ref,
+			Method: "GET",
+			Header: http.Header{"Accept": []string{"image/webp,*/*"}},
+		}, &ImageResponse{
+			SetStatusCode: func(code int) { status = code },
+			SetHeader:     headers.Set,
+			Body:          new(bytes.Buffer),
+		})
+
+		if status != 200 {
+			t.Fatalf("Unexpected status: %d", status)
+		}
+
+		if v := headers.Get("X-Cache"); v != expectedCache {
+			t.Errorf("X-Cache %s expected: %s", expectedCache, v)
+		}
+
+		if v := headers.Get("Vary"); v != "Accept" {
+			t.Errorf("Vary expected on %s: %s", expectedCache, v)
+		}
+	}
+}
@@ -61,14 +61,29 @@ func EncodeMediaUrl(config Config) func(string) string {
  * (from a string `repr`esentation previously produced by `encodeMediaUrl`).
  */
 func DecodeMediaUrl(config Config) func(string) (string, error) {
-	groupLen := len(config.GroupedBaseUrls)
-	prepared := make([]string, groupLen)
+	decode := DecodeMediaUrls(config)
 
-	for i, g := range config.GroupedBaseUrls {
-		prepared[i] = g[0]
+	return func(repr string) (string, error) {
+		_, urls, err := decode(repr)
+
+		if err != nil {
+			return "", err
+		}
+
+		return urls[0], nil
 	}
+}
 
-	return func(repr string) (string, error) {
+/**
+ * Returns a function that decodes a media URL
+ * (from a string `repr`esentation previously produced by `encodeMediaUrl`),
+ * as the index of its group (or -1 if none)
+ * and the alternative URLs according each base URL of this group.
+ */
+func DecodeMediaUrls(config Config) func(string) (int, []string, error) {
+	groupLen := len(config.GroupedBaseUrls)
+
+	return func(repr string) (int, []string, error) {
 		prefix := -1
 		unprefixed := ""
 
@@ -82,14 +97,14 @@ func DecodeMediaUrl(config Config) func(string) (string, error) {
 				px, err := strconv.Atoi(p)
 
 				if err != nil {
-					return "", err
+					return -1, nil, err
 				}
 
 				prefix = px
 			}
 
 			if prefix == -1 {
-				return "", errors.New(fmt.Sprintf("Invalid base64Ref '%s': second '_' separator expected after group index", repr))
+				return -1, nil, errors.New(fmt.Sprintf("Invalid base64Ref '%s': second '_' separator expected after group index", repr))
 			}
 		}
 
@@ -97,15 +112,28 @@ func DecodeMediaUrl(config Config) func(string) (string, error) {
 			decoded, err := base64Dec(unprefixed)
 
 			if err != nil {
-				return "", err
+				return -1, nil, err
 			} else if prefix < 0 || prefix >= groupLen {
-				return "", errors.New(fmt.Sprintf(
+				return -1, nil, errors.New(fmt.Sprintf(
 					"Invalid group index: %d", prefix))
-			} else {
-				return (prepared[prefix] + decoded), nil
 			}
+
+			group := config.GroupedBaseUrls[prefix]
+			urls := make([]string, len(group))
+
+			for i, base := range group {
+				urls[i] = base + decoded
+			}
+
+			return prefix, urls, nil
 		} else {
-			return base64Dec(repr)
+			decoded, err := base64Dec(repr)
+
+			if err != nil {
+				return -1, nil, err
+			}
+
+			return -1, []string{decoded}, nil
 		}
 	}
 }
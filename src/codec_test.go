@@ -1,6 +1,7 @@
 package nuggan
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -122,3 +123,46 @@ func TestDecodeMediaUrlInvalidGroupIndex(t *testing.T) {
 		t.Error("Error must be raised for invalid group index")
 	}
 }
+
+var decodeAll1 = DecodeMediaUrls(config1)
+
+func TestDecodeMediaUrlsInGroup2(t *testing.T) {
+	expected := []string{
+		"https://cdn0.iconfinder.com/data/icons/octicons/1024/mark-github-512.png",
+		"https://cdn1.iconfinder.com/data/icons/octicons/1024/mark-github-512.png",
+	}
+
+	group, got, err := decodeAll1("_1_L29jdGljb25zLzEwMjQvbWFyay1naXRodWItNTEyLnBuZw==")
+
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if group != 1 {
+		t.Errorf("Unexpected group: %d", group)
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("%v != %v\n", got, expected)
+	}
+}
+
+func TestDecodeMediaUrlsNoGroup(t *testing.T) {
+	expected := []string{
+		"https://blog.golang.org/lib/godoc/images/go-logo-blue.svg",
+	}
+
+	group, got, err := decodeAll1("aHR0cHM6Ly9ibG9nLmdvbGFuZy5vcmcvbGliL2dvZG9jL2ltYWdlcy9nby1sb2dvLWJsdWUuc3Zn")
+
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if group != -1 {
+		t.Errorf("No group expected: %d", group)
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("%v != %v\n", got, expected)
+	}
+}
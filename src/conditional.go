@@ -0,0 +1,101 @@
+package nuggan
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Returns the conditional headers to be forwarded to the origin,
+// according the validators of the client request
+// (`If-None-Match`, `If-Modified-Since`) for the given transformation.
+//
+// As the response Etag is `:originEtag/:etagPath`, only the entity tags
+// of the client for the same transformation are forwarded (unprefixed),
+// except the `placeholder` used when the origin provides no Etag.
+func originConditions(
+	header http.Header,
+	etagPath string,
+	placeholder string) http.Header {
+
+	cond := make(http.Header)
+
+	if header == nil {
+		return cond
+	}
+
+	noneMatch := header.Get("If-None-Match")
+	modifiedSince := header.Get("If-Modified-Since")
+
+	if noneMatch == "" {
+		if modifiedSince != "" {
+			cond.Set("If-Modified-Since", modifiedSince)
+		}
+
+		return cond
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since (RFC 7232)
+	suffix := "/" + etagPath
+	matched := false
+	tags := []string{}
+
+	for _, t := range strings.Split(noneMatch, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		t = strings.Trim(t, "\"")
+
+		if !strings.HasSuffix(t, suffix) {
+			continue
+		}
+
+		matched = true
+		origTag := t[:len(t)-len(suffix)]
+
+		if origTag != placeholder {
+			tags = append(tags, "\""+origTag+"\"")
+		}
+	}
+
+	if len(tags) > 0 {
+		cond.Set("If-None-Match", strings.Join(tags, ", "))
+	} else if matched && modifiedSince != "" {
+		cond.Set("If-Modified-Since", modifiedSince)
+	}
+
+	return cond
+}
+
+// Checks whether the validators of the client request match
+// the given response headers (`Etag`, `Last-Modified`).
+func notModified(header http.Header, respHeader map[string]string) bool {
+	if header == nil {
+		return false
+	}
+
+	etag := respHeader["Etag"]
+
+	if noneMatch := header.Get("If-None-Match"); noneMatch != "" {
+		for _, t := range strings.Split(noneMatch, ",") {
+			t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+
+			if t == "*" || (etag != "" && strings.Trim(t, "\"") == etag) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	modifiedSince, err := http.ParseTime(header.Get("If-Modified-Since"))
+
+	if err != nil {
+		return false
+	}
+
+	lastModified, err := http.ParseTime(respHeader["Last-Modified"])
+
+	if err != nil {
+		return false
+	}
+
+	return !lastModified.After(modifiedSince)
+}
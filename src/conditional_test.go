@@ -0,0 +1,113 @@
+package nuggan
+
+import (
+	"net/http"
+	"testing"
+)
+
+const condEtagPath = "optimg/0/0/-/-/128/-/-"
+
+func TestOriginConditionsNone(t *testing.T) {
+	got := originConditions(http.Header{}, condEtagPath, "_2_ref")
+
+	if len(got) != 0 {
+		t.Errorf("No condition expected: %v", got)
+	}
+}
+
+func TestOriginConditionsNoneMatch(t *testing.T) {
+	header := http.Header{}
+
+	header.Set("If-None-Match", "\"abc/optimg/0/0/-/-/128/-/-\", W/\"def/optimg/0/0/-/-/128/-/-\", \"ghi/optimg/0/0/-/-/64/-/-\"")
+	header.Set("If-Modified-Since", "Wed, 21 Oct 2015 07:28:00 GMT")
+
+	got := originConditions(header, condEtagPath, "_2_ref")
+
+	if v := got.Get("If-None-Match"); v != "\"abc\", \"def\"" {
+		t.Errorf("Unexpected If-None-Match: %s", v)
+	}
+
+	if v := got.Get("If-Modified-Since"); v != "" {
+		t.Errorf("If-Modified-Since must not be forwarded: %s", v)
+	}
+}
+
+func TestOriginConditionsNoneMatchOtherTransformation(t *testing.T) {
+	header := http.Header{}
+
+	header.Set("If-None-Match", "abc/optimg/0/0/-/-/64/-/-")
+	header.Set("If-Modified-Since", "Wed, 21 Oct 2015 07:28:00 GMT")
+
+	got := originConditions(header, condEtagPath, "_2_ref")
+
+	if len(got) != 0 {
+		t.Errorf("No condition expected: %v", got)
+	}
+}
+
+func TestOriginConditionsPlaceholder(t *testing.T) {
+	header := http.Header{}
+
+	header.Set("If-None-Match", "_2_ref/optimg/0/0/-/-/128/-/-")
+	header.Set("If-Modified-Since", "Wed, 21 Oct 2015 07:28:00 GMT")
+
+	got := originConditions(header, condEtagPath, "_2_ref")
+
+	if v := got.Get("If-None-Match"); v != "" {
+		t.Errorf("Placeholder must not be forwarded: %s", v)
+	}
+
+	if v := got.Get("If-Modified-Since"); v != "Wed, 21 Oct 2015 07:28:00 GMT" {
+		t.Errorf("Unexpected If-Modified-Since: %s", v)
+	}
+}
+
+func TestOriginConditionsModifiedSince(t *testing.T) {
+	header := http.Header{}
+
+	header.Set("If-Modified-Since", "Wed, 21 Oct 2015 07:28:00 GMT")
+
+	got := originConditions(header, condEtagPath, "_2_ref")
+
+	if v := got.Get("If-Modified-Since"); v != "Wed, 21 Oct 2015 07:28:00 GMT" {
+		t.Errorf("Unexpected If-Modified-Since: %s", v)
+	}
+}
+
+var cachedHeader1 = map[string]string{
+	"Etag":          "abc/optimg/0/0/-/-/128/-/-",
+	"Last-Modified": "Wed, 21 Oct 2015 07:28:00 GMT",
+}
+
+func TestNotModifiedEtag(t *testing.T) {
+	header := http.Header{}
+
+	header.Set("If-None-Match", "\"xyz\", abc/optimg/0/0/-/-/128/-/-")
+
+	if !notModified(header, cachedHeader1) {
+		t.Error("Matching Etag must be not modified")
+	}
+
+	header.Set("If-None-Match", "xyz/optimg/0/0/-/-/128/-/-")
+	header.Set("If-Modified-Since", "Wed, 21 Oct 2015 07:28:00 GMT")
+
+	if notModified(header, cachedHeader1) {
+		t.Error("Other Etag must be modified")
+	}
+}
+
+func TestNotModifiedSince(t *testing.T) {
+	header := http.Header{}
+
+	header.Set("If-Modified-Since", "Wed, 21 Oct 2015 07:28:00 GMT")
+
+	if !notModified(header, cachedHeader1) {
+		t.Error("Must not be modified since Last-Modified")
+	}
+
+	header.Set("If-Modified-Since", "Tue, 20 Oct 2015 07:28:00 GMT")
+
+	if notModified(header, cachedHeader1) {
+		t.Error("Must be modified since previous day")
+	}
+}
@@ -5,20 +5,55 @@ import (
 	"fmt"
 	toml "github.com/pelletier/go-toml"
 	"io"
+	"net/url"
+	"os"
 	"strings"
+	"time"
 )
 
 type HttpUrl = string
 
+// Settings of the HTTP client used to fetch the media from their origin
+type OriginConfig struct {
+	ConnectTimeout      time.Duration // defaulted to 10s
+	ReadTimeout         time.Duration // whole response; defaulted to 60s
+	MaxBodySize         int64         // in bytes, unlimited if 0
+	MaxRedirects        int           // defaulted to 10, no redirect if < 0
+	UserAgent           string        // defaulted to 'nuggan'
+	MaxIdleConns        int           // defaulted to 100
+	MaxIdleConnsPerHost int           // defaulted to 2
+	IdleConnTimeout     time.Duration // defaulted to 90s
+	Proxy               HttpUrl       // defaulted to environment
+
+	// Checks for the media not from a configured group (non-strict)
+	AllowedHosts   []string // if any, only these hosts (& subdomains)
+	DeniedHosts    []string // hosts (& subdomains)
+	DeniedNetworks []string // CIDR, in addition to private networks
+}
+
 type Config struct {
-	GroupedBaseUrls [][]HttpUrl
-	RoutePrefix     string // defaulted to '/optimg' is missing
-	Strict          bool
-	CacheControl    string
+	GroupedBaseUrls  [][]HttpUrl
+	BaseUrlSelection string // first (default), round-robin or random
+	RoutePrefix      string // defaulted to '/optimg' is missing
+	Strict           bool
+	CacheControl     string
+	Secrets          []string      // if any, requests must be signed
+	AcceptFormats    []string      // formats negotiated according `Accept`
+	MemoryCacheSize  int64         // in bytes, no memory cache if 0
+	MemoryCacheTtl   time.Duration // no expiry if 0
+	DiskCacheDir     string        // no disk cache if empty
+	DiskCacheSize    int64         // in bytes
+	DiskCacheTtl     time.Duration // no expiry if 0
+	Origin           OriginConfig
+	Limits           LimitsConfig
+	Presets          map[string]PresetConfig
+	PresetsOnly      bool // if true, only the preset routes are allowed
+	Watermarks       map[string]WatermarkConfig
+	FontsDir         string // only fonts of the text overlays, if set
 }
 
 func (c Config) String() string {
-	return fmt.Sprintf("{ GroupedBaseUrls: %v, RoutePrefix: %s, Struct: %v }", c.GroupedBaseUrls, c.RoutePrefix, c.Strict)
+	return fmt.Sprintf("{ GroupedBaseUrls: %v, RoutePrefix: %s, Struct: %v, Signed: %v }", c.GroupedBaseUrls, c.RoutePrefix, c.Strict, len(c.Secrets) > 0)
 }
 
 func LoadConfig(reader io.Reader) (Config, error) {
@@ -44,6 +79,108 @@ func LoadConfig(reader io.Reader) (Config, error) {
 		}
 	}
 
+	for i, s := range config.Secrets {
+		if s == "" {
+			return config, errors.New(
+				fmt.Sprintf("Secret #%d is empty", i))
+		}
+	}
+
+	if config.MemoryCacheSize < 0 {
+		return config, errors.New(fmt.Sprintf(
+			"Invalid memory cache size: %d", config.MemoryCacheSize))
+	}
+
+	if config.DiskCacheDir != "" && config.DiskCacheSize <= 0 {
+		return config, errors.New(fmt.Sprintf(
+			"Invalid disk cache size: %d", config.DiskCacheSize))
+	}
+
+	if config.Origin.MaxBodySize < 0 {
+		return config, errors.New(fmt.Sprintf(
+			"Invalid origin max body size: %d",
+			config.Origin.MaxBodySize))
+	}
+
+	if config.Origin.Proxy != "" {
+		u, err := url.Parse(config.Origin.Proxy)
+
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return config, errors.New(fmt.Sprintf(
+				"Invalid origin proxy: %s", config.Origin.Proxy))
+		}
+	}
+
+	for _, c := range config.Origin.DeniedNetworks {
+		if _, err := parseNetwork(c); err != nil {
+			return config, err
+		}
+	}
+
+	limits := config.Limits
+
+	if limits.MaxSourcePixels < 0 || limits.MaxSourceSize < 0 ||
+		limits.MaxOutputWidth < 0 || limits.MaxOutputHeight < 0 ||
+		limits.MaxUpscale < 0 {
+
+		return config, errors.New("Invalid negative limit")
+	}
+
+	for _, s := range limits.AllowedSizes {
+		if _, _, err := parseSize(s); err != nil {
+			return config, err
+		}
+	}
+
+	if config.FontsDir != "" {
+		if fi, err := os.Stat(config.FontsDir); err != nil || !fi.IsDir() {
+			return config, errors.New(fmt.Sprintf(
+				"Invalid fonts directory: %s", config.FontsDir))
+		}
+	}
+
+	encode := EncodeMediaUrl(config)
+
+	for name, watermark := range config.Watermarks {
+		if err := validateWatermark(name, watermark, encode); err != nil {
+			return config, err
+		}
+	}
+
+	for name, preset := range config.Presets {
+		if err := validatePreset(name, preset, config.Limits); err != nil {
+			return config, err
+		}
+
+		params, _ := parseParams(preset.Params)
+
+		if w := params.Watermark; w != nil {
+			if _, ok := config.Watermarks[w.Name]; !ok {
+				return config, errors.New(fmt.Sprintf(
+					"Unknown watermark for preset '%s': %s", name, w.Name))
+			}
+		}
+	}
+
+	if config.PresetsOnly && len(config.Presets) == 0 {
+		return config, errors.New("No preset configured")
+	}
+
+	for _, f := range config.AcceptFormats {
+		if _, ok := outputFormats[strings.ToLower(f)]; !ok {
+			return config, errors.New(
+				fmt.Sprintf("Unsupported accept format: %s", f))
+		}
+	}
+
+	switch config.BaseUrlSelection {
+	case "", selectFirst, selectRoundRobin, selectRandom:
+
+	default:
+		return config, errors.New(fmt.Sprintf(
+			"Invalid base URL selection: %s", config.BaseUrlSelection))
+	}
+
 	config.RoutePrefix = strings.TrimSpace(config.RoutePrefix)
 
 	if config.RoutePrefix == "" {
@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestEmptyGroups(t *testing.T) {
@@ -121,3 +122,327 @@ routePrefix = "in/alid"
 		t.Errorf("Expected error for invalid route prefix: %v", err)
 	}
 }
+
+func TestSecretsConfig(t *testing.T) {
+	got, err := LoadConfig(strings.NewReader(`
+groupedBaseUrls = [
+  [
+    "https://upload.wikimedia.org/wikipedia/commons"
+  ]
+]
+secrets = [ "current", "previous" ]
+`))
+
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	expected := []string{"current", "previous"}
+
+	if !reflect.DeepEqual(got.Secrets, expected) {
+		t.Errorf("%v != %v\n", got.Secrets, expected)
+	}
+}
+
+func TestEmptySecretConfig(t *testing.T) {
+	_, err := LoadConfig(strings.NewReader(`
+groupedBaseUrls = [
+  [
+    "https://upload.wikimedia.org/wikipedia/commons"
+  ]
+]
+secrets = [ "current", "" ]
+`))
+
+	expected := "Secret #1 is empty"
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error '%s': %v", expected, err)
+	}
+}
+
+func TestInvalidAcceptFormatsConfig(t *testing.T) {
+	_, err := LoadConfig(strings.NewReader(`
+groupedBaseUrls = [
+  [
+    "https://upload.wikimedia.org/wikipedia/commons"
+  ]
+]
+acceptFormats = [ "webp", "avif" ]
+`))
+
+	expected := "Unsupported accept format: avif"
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error '%s': %v", expected, err)
+	}
+}
+
+func TestMemoryCacheConfig(t *testing.T) {
+	got, err := LoadConfig(strings.NewReader(`
+groupedBaseUrls = [
+  [
+    "https://upload.wikimedia.org/wikipedia/commons"
+  ]
+]
+memoryCacheSize = 67108864
+memoryCacheTtl = "1h"
+`))
+
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if got.MemoryCacheSize != 67108864 || got.MemoryCacheTtl != time.Hour {
+		t.Errorf("Unexpected memory cache: %d, %v",
+			got.MemoryCacheSize, got.MemoryCacheTtl)
+	}
+}
+
+func TestDiskCacheWithoutSizeConfig(t *testing.T) {
+	_, err := LoadConfig(strings.NewReader(`
+groupedBaseUrls = [
+  [
+    "https://upload.wikimedia.org/wikipedia/commons"
+  ]
+]
+diskCacheDir = "/tmp/nuggan"
+`))
+
+	expected := "Invalid disk cache size: 0"
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error '%s': %v", expected, err)
+	}
+}
+
+func TestOriginConfig(t *testing.T) {
+	got, err := LoadConfig(strings.NewReader(`
+groupedBaseUrls = [
+  [
+    "https://upload.wikimedia.org/wikipedia/commons"
+  ]
+]
+
+[origin]
+connectTimeout = "2s"
+readTimeout = "15s"
+maxBodySize = 10485760
+maxRedirects = 3
+userAgent = "nuggan-test"
+maxIdleConnsPerHost = 8
+proxy = "http://proxy:3128"
+`))
+
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	expected := OriginConfig{
+		ConnectTimeout:      2 * time.Second,
+		ReadTimeout:         15 * time.Second,
+		MaxBodySize:         10485760,
+		MaxRedirects:        3,
+		UserAgent:           "nuggan-test",
+		MaxIdleConnsPerHost: 8,
+		Proxy:               "http://proxy:3128",
+	}
+
+	if !reflect.DeepEqual(got.Origin, expected) {
+		t.Errorf("%v != %v\n", got.Origin, expected)
+	}
+}
+
+func TestInvalidOriginProxyConfig(t *testing.T) {
+	_, err := LoadConfig(strings.NewReader(`
+groupedBaseUrls = [
+  [
+    "https://upload.wikimedia.org/wikipedia/commons"
+  ]
+]
+
+[origin]
+proxy = "proxy:3128"
+`))
+
+	expected := "Invalid origin proxy: proxy:3128"
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error '%s': %v", expected, err)
+	}
+}
+
+func TestInvalidOriginDeniedNetworkConfig(t *testing.T) {
+	_, err := LoadConfig(strings.NewReader(`
+groupedBaseUrls = [
+  [
+    "https://upload.wikimedia.org/wikipedia/commons"
+  ]
+]
+
+[origin]
+deniedNetworks = [ "203.0.113.0" ]
+`))
+
+	expected := "Invalid denied network: 203.0.113.0"
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error '%s': %v", expected, err)
+	}
+}
+
+func TestInvalidAllowedSizeConfig(t *testing.T) {
+	_, err := LoadConfig(strings.NewReader(`
+groupedBaseUrls = [
+  [
+    "https://upload.wikimedia.org/wikipedia/commons"
+  ]
+]
+
+[limits]
+maxOutputWidth = 2048
+allowedSizes = [ "320x-", "640" ]
+`))
+
+	expected := "Invalid allowed size '640': expected WxH"
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error '%s': %v", expected, err)
+	}
+}
+
+func TestPresetsConfig(t *testing.T) {
+	got, err := LoadConfig(strings.NewReader(`
+groupedBaseUrls = [
+  [
+    "https://upload.wikimedia.org/wikipedia/commons"
+  ]
+]
+presetsOnly = true
+
+[presets.thumbnail]
+resize = "320/-"
+compression = "7"
+params = "fit:cover"
+format = "webp"
+`))
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := map[string]PresetConfig{
+		"thumbnail": {
+			Resize:      "320/-",
+			Compression: "7",
+			Params:      "fit:cover",
+			Format:      "webp",
+		},
+	}
+
+	if !got.PresetsOnly || !reflect.DeepEqual(got.Presets, expected) {
+		t.Errorf("%v != %v\n", got.Presets, expected)
+	}
+}
+
+func TestInvalidPresetConfig(t *testing.T) {
+	_, err := LoadConfig(strings.NewReader(`
+groupedBaseUrls = [
+  [
+    "https://upload.wikimedia.org/wikipedia/commons"
+  ]
+]
+
+[limits]
+maxOutputWidth = 1024
+
+[presets.thumbnail]
+resize = "2048/-"
+`))
+
+	expected := "Invalid transformation for preset 'thumbnail': Output width 2048 exceeds the maximum: 1024"
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error '%s': %v", expected, err)
+	}
+}
+
+func TestPresetsOnlyWithoutPresetConfig(t *testing.T) {
+	_, err := LoadConfig(strings.NewReader(`
+groupedBaseUrls = [
+  [
+    "https://upload.wikimedia.org/wikipedia/commons"
+  ]
+]
+presetsOnly = true
+`))
+
+	expected := "No preset configured"
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error '%s': %v", expected, err)
+	}
+}
+
+func TestWatermarksConfig(t *testing.T) {
+	config, err := LoadConfig(strings.NewReader(`
+groupedBaseUrls = [
+  [
+    "https://upload.wikimedia.org/wikipedia/commons"
+  ]
+]
+
+[watermarks.logo]
+url = "https://upload.wikimedia.org/wikipedia/commons/logo.png"
+position = "northwest"
+margin = 10
+opacity = 0.5
+size = 0.2
+
+[presets.stamped]
+params = "watermark:logo"
+`))
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	logo := config.Watermarks["logo"]
+
+	if logo.Position != "northwest" || logo.Margin != 10 || logo.Opacity != 0.5 || logo.Size != 0.2 {
+		t.Errorf("Unexpected watermark: %v", logo)
+	}
+}
+
+func TestInvalidWatermarksConfig(t *testing.T) {
+	for expected, watermark := range map[string]string{
+		"Either file or url expected for watermark 'logo'": `
+[watermarks.logo]
+margin = 10`,
+		"URL for watermark 'logo' is not from a configured group: https://other.org/logo.png": `
+[watermarks.logo]
+url = "https://other.org/logo.png"`,
+		"Invalid watermark 'logo': Unsupported watermark position 'top': expected center, north, northeast, east, southeast, south, southwest, west or northwest": `
+[watermarks.logo]
+url = "https://upload.wikimedia.org/wikipedia/commons/logo.png"
+position = "top"`,
+		"Unknown watermark for preset 'stamped': other": `
+[watermarks.logo]
+url = "https://upload.wikimedia.org/wikipedia/commons/logo.png"
+
+[presets.stamped]
+params = "watermark:other"`,
+	} {
+		_, err := LoadConfig(strings.NewReader(`
+groupedBaseUrls = [
+  [
+    "https://upload.wikimedia.org/wikipedia/commons"
+  ]
+]
+` + watermark))
+
+		if err == nil || err.Error() != expected {
+			t.Errorf("Expected error '%s': %v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package nuggan
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Crop coordinate or dimension, either absolute (in pixels)
+// or relative to the source dimension.
+type CropValue struct {
+	Pixels   int     // if not relative (-1 if none)
+	Fraction float64 // if relative, of the source dimension
+	Relative bool
+
+	// For a coordinate, whether it's from the right (or bottom) edge
+	FromEnd bool
+}
+
+// Unspecified dimension
+var NoCropValue = CropValue{Pixels: -1}
+
+// Parses a crop coordinate (`dimension` = false) or dimension,
+// either in pixels (e.g. `10`), as percentage (e.g. `10p`)
+// or as fraction (e.g. `0.25`).
+//
+// A negative coordinate is from the right (or bottom) edge.
+func ParseCropValue(repr string, dimension bool) (CropValue, error) {
+	fromEnd := strings.HasPrefix(repr, "-")
+	v := strings.TrimPrefix(repr, "-")
+
+	if fromEnd && dimension {
+		// Negative dimension is ignored
+		return NoCropValue, nil
+	}
+
+	value := CropValue{FromEnd: fromEnd}
+
+	if strings.HasSuffix(v, "p") {
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(v, "p"), 64)
+
+		if err != nil || pct < 0 || pct > 100 {
+			return value, errors.New(fmt.Sprintf(
+				"Invalid percentage '%s': expected 0p to 100p", repr))
+		}
+
+		value.Fraction = pct / 100
+		value.Relative = true
+	} else if strings.Contains(v, ".") {
+		f, err := strconv.ParseFloat(v, 64)
+
+		if err != nil || f < 0 || f > 1 {
+			return value, errors.New(fmt.Sprintf(
+				"Invalid fraction '%s': expected 0.0 to 1.0", repr))
+		}
+
+		value.Fraction = f
+		value.Relative = true
+	} else {
+		px, err := strconv.Atoi(v)
+
+		if err != nil {
+			return value, err
+		}
+
+		value.Pixels = px
+	}
+
+	return value, nil
+}
+
+// Returns the value in pixels, for the given source dimension
+// (-1 if none).
+func (v CropValue) Resolve(size int) int {
+	px := v.Pixels
+
+	if v.Relative {
+		px = int(math.Round(v.Fraction * float64(size)))
+	} else if px < 0 {
+		return -1
+	}
+
+	if v.FromEnd {
+		return size - px
+	}
+
+	return px
+}
+
+// Returns the absolute value in pixels, or -1 if relative (or none).
+func (v CropValue) absolute() int {
+	if v.Relative || v.FromEnd {
+		return -1
+	}
+
+	return v.Pixels
+}
+
+// Returns the normalized representation
+// (`-` if none, fraction if relative).
+func (v CropValue) String() string {
+	repr := ""
+
+	if v.Relative {
+		repr = strconv.FormatFloat(v.Fraction, 'f', -1, 64)
+
+		if !strings.Contains(repr, ".") {
+			repr = repr + ".0"
+		}
+	} else if v.Pixels < 0 {
+		return "-"
+	} else {
+		repr = strconv.Itoa(v.Pixels)
+	}
+
+	if v.FromEnd {
+		return "-" + repr
+	}
+
+	return repr
+}
@@ -0,0 +1,71 @@
+package nuggan
+
+import (
+	"testing"
+)
+
+func TestParseCropValue(t *testing.T) {
+	// Resolved for 200 pixels
+	for repr, expected := range map[string]int{
+		"10":    10,
+		"10p":   20,
+		"0.25":  50,
+		"-10":   190,
+		"-0.25": 150,
+		"100p":  200,
+	} {
+		v, err := ParseCropValue(repr, false)
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if got := v.Resolve(200); got != expected {
+			t.Errorf("%s: %d != %d", repr, got, expected)
+		}
+	}
+}
+
+func TestParseCropDimension(t *testing.T) {
+	v, err := ParseCropValue("-10", true)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if got := v.Resolve(200); got != -1 {
+		t.Errorf("Negative dimension must be ignored: %d", got)
+	}
+}
+
+func TestInvalidCropValue(t *testing.T) {
+	for _, repr := range []string{"abc", "101p", "1.5", "xp"} {
+		if _, err := ParseCropValue(repr, false); err == nil {
+			t.Errorf("Invalid value must be refused: %s", repr)
+		}
+	}
+}
+
+func TestCropValueString(t *testing.T) {
+	for repr, expected := range map[string]string{
+		"10":   "10",
+		"25p":  "0.25",
+		"0.25": "0.25",
+		"-1.":  "-1.0",
+		"-10":  "-10",
+	} {
+		v, err := ParseCropValue(repr, false)
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if got := v.String(); got != expected {
+			t.Errorf("%s: %s != %s", repr, got, expected)
+		}
+	}
+
+	if got := NoCropValue.String(); got != "-" {
+		t.Errorf("Unexpected representation: %s", got)
+	}
+}
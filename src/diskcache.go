@@ -0,0 +1,330 @@
+package nuggan
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Metadata stored as first line of a cached file
+type diskEntryMeta struct {
+	Key     string
+	Header  map[string]string
+	Created time.Time
+}
+
+type diskEntry struct {
+	size int64
+	used time.Time
+}
+
+// On-disk cache, sharded by key hash (`:dir/ab/cd/abcd...`),
+// bounded by the total size of the cached files (LRU),
+// with entries optionally expiring after a TTL.
+//
+// Files are written in a temporary file and then renamed,
+// so concurrent writers (or readers) never see partial entries.
+type diskCache struct {
+	dir      string
+	maxBytes int64
+	ttl      time.Duration
+
+	mutex   sync.Mutex
+	size    int64
+	entries map[string]*diskEntry // by file path
+}
+
+// Shard directories (`ab` or `ab/cd`), relative to the cache directory
+var diskShardPattern = regexp.MustCompile(`^[0-9a-f]{2}(/[0-9a-f]{2})?$`)
+
+// Cached files (`ab/cd/abcd...`), relative to the cache directory
+var diskEntryPattern = regexp.MustCompile(
+	`^([0-9a-f]{2})/([0-9a-f]{2})/([0-9a-f]{64})$`)
+
+// Temporary files of interrupted writes, relative to the cache directory
+var diskTempPattern = regexp.MustCompile(`^[0-9a-f]{2}/[0-9a-f]{2}/\.tmp-[0-9]+$`)
+
+// - dir: Cache directory (created if missing)
+// - maxBytes: Maximum total size of the cached files
+// - ttl: Time to live of each entry (or 0 if no expiry)
+func newDiskCache(
+	dir string,
+	maxBytes int64,
+	ttl time.Duration) (*diskCache, error) {
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &diskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		entries:  make(map[string]*diskEntry),
+	}
+
+	// Index the files from a previous run,
+	// ignoring what's not from the cache layout
+	err := filepath.Walk(dir, func(
+		path string, info os.FileInfo, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+
+		if err != nil || rel == "." {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			if !diskShardPattern.MatchString(rel) {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if diskTempPattern.MatchString(rel) {
+			// Interrupted write
+			return os.Remove(path)
+		}
+
+		m := diskEntryPattern.FindStringSubmatch(rel)
+
+		if m == nil || m[1] != m[3][0:2] || m[2] != m[3][2:4] {
+			return nil
+		}
+
+		c.entries[path] = &diskEntry{
+			size: info.Size(),
+			used: info.ModTime(),
+		}
+
+		c.size += info.Size()
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	c.evict()
+	c.mutex.Unlock()
+
+	return c, nil
+}
+
+func (c *diskCache) Get(key string) (*cachedImage, bool) {
+	path := c.path(key)
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, false
+	}
+
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	line, err := reader.ReadBytes('\n')
+
+	if err != nil {
+		return nil, false
+	}
+
+	meta := diskEntryMeta{}
+
+	if err := json.Unmarshal(line, &meta); err != nil || meta.Key != key {
+		return nil, false // corrupted or hash collision
+	}
+
+	if c.ttl > 0 && time.Since(meta.Created) > c.ttl {
+		c.mutex.Lock()
+		c.remove(path)
+		c.mutex.Unlock()
+
+		return nil, false
+	}
+
+	body, err := ioutil.ReadAll(reader)
+
+	if err != nil {
+		return nil, false
+	}
+
+	now := time.Now()
+
+	c.mutex.Lock()
+
+	if entry, ok := c.entries[path]; ok {
+		entry.used = now
+	}
+
+	c.mutex.Unlock()
+
+	// Keep track of usage across restarts
+	os.Chtimes(path, now, now)
+
+	return &cachedImage{Header: meta.Header, Body: body}, true
+}
+
+func (c *diskCache) Set(key string, image *cachedImage) {
+	path := c.path(key)
+
+	if err := c.write(path, key, image); err != nil {
+		log.Printf("WARNING: Fails to write disk cache: %s\n", err)
+		return
+	}
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if entry, ok := c.entries[path]; ok {
+		c.size -= entry.size
+	}
+
+	c.entries[path] = &diskEntry{size: info.Size(), used: time.Now()}
+	c.size += info.Size()
+
+	c.evict()
+}
+
+func (c *diskCache) write(path string, key string, image *cachedImage) error {
+	meta, err := json.Marshal(diskEntryMeta{
+		Key:     key,
+		Header:  image.Header,
+		Created: time.Now(),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(append(meta, '\n'))
+
+	if err == nil {
+		_, err = tmp.Write(image.Body)
+	}
+
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+// Returns the sharded path for the given key.
+func (c *diskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+
+	return filepath.Join(c.dir, name[0:2], name[2:4], name)
+}
+
+// Removes the least recently used entries while the cache is too large
+// (must be called with lock).
+func (c *diskCache) evict() {
+	if c.size <= c.maxBytes {
+		return
+	}
+
+	paths := make([]string, 0, len(c.entries))
+
+	for p := range c.entries {
+		paths = append(paths, p)
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		return c.entries[paths[i]].used.Before(c.entries[paths[j]].used)
+	})
+
+	for _, p := range paths {
+		if c.size <= c.maxBytes {
+			break
+		}
+
+		c.remove(p)
+	}
+}
+
+// Must be called with lock.
+func (c *diskCache) remove(path string) {
+	if entry, ok := c.entries[path]; ok {
+		c.size -= entry.size
+
+		delete(c.entries, path)
+	}
+
+	os.Remove(path)
+}
+
+// ---
+
+// Cache made of several levels (e.g. memory then disk),
+// where an entry found in a lower level is copied to the upper ones.
+type tieredCache []imageCache
+
+func (c tieredCache) Get(key string) (*cachedImage, bool) {
+	for i, level := range c {
+		if image, ok := level.Get(key); ok {
+			for _, upper := range c[:i] {
+				upper.Set(key, image)
+			}
+
+			return image, true
+		}
+	}
+
+	return nil, false
+}
+
+func (c tieredCache) Set(key string, image *cachedImage) {
+	for _, level := range c {
+		level.Set(key, image)
+	}
+}
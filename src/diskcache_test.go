@@ -0,0 +1,186 @@
+package nuggan
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testDiskCache(t *testing.T, maxBytes int64, ttl time.Duration) (*diskCache, func()) {
+	dir, err := ioutil.TempDir("", "nuggan-cache")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	cache, err := newDiskCache(dir, maxBytes, ttl)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return cache, func() { os.RemoveAll(dir) }
+}
+
+func TestDiskCacheGetSet(t *testing.T) {
+	cache, cleanup := testDiskCache(t, 1024, 0)
+
+	defer cleanup()
+
+	if _, ok := cache.Get("a"); ok {
+		t.Error("Cache must be initially empty")
+	}
+
+	img := &cachedImage{
+		Header: map[string]string{
+			"Content-Type": "image/png",
+			"Etag":         "abc/optimg/0/0/-/-/-/-/-",
+		},
+		Body: []byte("\x89PNG\n\x00data"),
+	}
+
+	cache.Set("a", img)
+
+	got, ok := cache.Get("a")
+
+	if !ok || !reflect.DeepEqual(got, img) {
+		t.Errorf("Cached image expected: %v", got)
+	}
+}
+
+func TestDiskCacheRestart(t *testing.T) {
+	cache, cleanup := testDiskCache(t, 1024, 0)
+
+	defer cleanup()
+
+	cache.Set("a", testImage(10))
+
+	restarted, err := newDiskCache(cache.dir, 1024, 0)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if restarted.size != cache.size {
+		t.Errorf("Size must be restored: %d != %d",
+			restarted.size, cache.size)
+	}
+
+	if _, ok := restarted.Get("a"); !ok {
+		t.Error("Entry must be kept across restart")
+	}
+}
+
+func TestDiskCacheForeignFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuggan-cache")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer os.RemoveAll(dir)
+
+	foreign := []string{
+		"notes.txt", ".tmp-123", "other/.tmp-456", "ab/cd/data", "ab/cd/ef/.tmp-7",
+	}
+
+	for _, name := range append(foreign, "ab/cd/.tmp-789") {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+
+		os.MkdirAll(filepath.Dir(path), 0755)
+
+		if err := ioutil.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	// Evicts any indexed file
+	cache, err := newDiskCache(dir, 1, 0)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if cache.size != 0 || len(cache.entries) != 0 {
+		t.Errorf("Foreign files must not be indexed: %v", cache.entries)
+	}
+
+	for _, name := range foreign {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Foreign file must be kept: %s", name)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "ab/cd/.tmp-789")); err == nil {
+		t.Error("Interrupted write must be removed")
+	}
+}
+
+func TestDiskCacheEviction(t *testing.T) {
+	cache, cleanup := testDiskCache(t, 2500, 0) // ~ 2 entries
+
+	defer cleanup()
+
+	cache.Set("a", testImage(1000))
+	time.Sleep(5 * time.Millisecond)
+
+	cache.Set("b", testImage(1000))
+	time.Sleep(5 * time.Millisecond)
+
+	cache.Get("a") // 'b' becomes least recently used
+	time.Sleep(5 * time.Millisecond)
+
+	cache.Set("c", testImage(1000))
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("Least recently used entry must be evicted")
+	}
+
+	if _, ok := cache.Get("a"); !ok {
+		t.Error("Recently used entry must be kept")
+	}
+
+	if cache.size > 2500 {
+		t.Errorf("Cache size exceeded: %d", cache.size)
+	}
+}
+
+func TestDiskCacheTtl(t *testing.T) {
+	cache, cleanup := testDiskCache(t, 1024, time.Millisecond)
+
+	defer cleanup()
+
+	cache.Set("a", testImage(10))
+
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := cache.Get("a"); ok {
+		t.Error("Expired entry must not be returned")
+	}
+
+	if cache.size != 0 {
+		t.Errorf("Expired entry must be released: %d", cache.size)
+	}
+}
+
+func TestTieredCache(t *testing.T) {
+	disk, cleanup := testDiskCache(t, 1024, 0)
+
+	defer cleanup()
+
+	disk.Set("a", testImage(10))
+
+	memory := newMemoryCache(1024, 0)
+	cache := tieredCache{memory, disk}
+
+	if _, ok := cache.Get("a"); !ok {
+		t.Error("Entry must be found in lower level")
+	}
+
+	if _, ok := memory.Get("a"); !ok {
+		t.Error("Entry must be copied to upper level")
+	}
+}
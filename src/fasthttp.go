@@ -4,6 +4,8 @@ import (
 	"github.com/davidbyttow/govips/pkg/vips"
 	"github.com/valyala/fasthttp"
 	"log"
+	"net/http"
+	"net/url"
 	"strings"
 )
 
@@ -27,8 +29,10 @@ func fasthttpHandler(conf Config) func(*fasthttp.RequestCtx) {
 
 		request := ImageRequest{
 			Path:    path,
+			Query:   fasthttpQuery(ctx),
 			Method:  string(ctx.Method()),
 			Referer: fasthttpReferer(ctx),
+			Header:  fasthttpHeader(ctx),
 		}
 
 		resp := ImageResponse{
@@ -70,3 +74,23 @@ func fasthttpReferer(ctx *fasthttp.RequestCtx) ImageReferer {
 		}
 	}
 }
+
+func fasthttpQuery(ctx *fasthttp.RequestCtx) url.Values {
+	query := make(url.Values)
+
+	ctx.QueryArgs().VisitAll(func(k []byte, v []byte) {
+		query.Add(string(k), string(v))
+	})
+
+	return query
+}
+
+func fasthttpHeader(ctx *fasthttp.RequestCtx) http.Header {
+	header := make(http.Header)
+
+	ctx.Request.Header.VisitAll(func(k []byte, v []byte) {
+		header.Add(string(k), string(v))
+	})
+
+	return header
+}
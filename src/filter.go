@@ -0,0 +1,301 @@
+package nuggan
+
+import (
+	"bytes"
+	"github.com/davidbyttow/govips/pkg/vips"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+)
+
+// Filters applied on an image, once resized.
+//
+// The zero value applies no filter.
+type Filters struct {
+	Blur      float64 // gaussian blur sigma (e.g. 5), none if 0
+	Sharpen   float64 // unsharp mask sigma (e.g. 0.5), none if 0
+	Grayscale bool
+
+	// Adjustments from -100 to 100 (%), none if 0
+	Brightness float64
+	Contrast   float64
+	Saturation float64
+}
+
+// Maximum sigma of the gaussian blur
+const maxBlur = 100.0
+
+// Maximum sigma of the sharpening
+const maxSharpen = 10.0
+
+// Names of the numeric filters, as transformation parameters
+var filterNames = []string{
+	"blur", "sharpen", "brightness", "contrast", "saturation"}
+
+// Returns the value of the named numeric filter.
+func (f *Filters) value(name string) *float64 {
+	switch name {
+	case "blur":
+		return &f.Blur
+
+	case "sharpen":
+		return &f.Sharpen
+
+	case "brightness":
+		return &f.Brightness
+
+	case "contrast":
+		return &f.Contrast
+
+	default:
+		return &f.Saturation
+	}
+}
+
+// Checks the filter values.
+func (f Filters) validate() error {
+	if f.Blur < 0 || f.Blur > maxBlur {
+		return invalidOption("blur",
+			"Invalid blur '%v': expected 0 to %v", f.Blur, maxBlur)
+	}
+
+	if f.Sharpen < 0 || f.Sharpen > maxSharpen {
+		return invalidOption("sharpen",
+			"Invalid sharpen '%v': expected 0 to %v", f.Sharpen, maxSharpen)
+	}
+
+	for _, name := range filterNames[2:] {
+		if v := *f.value(name); v < -100 || v > 100 {
+			return invalidOption(name,
+				"Invalid %s '%v': expected -100 to 100", name, v)
+		}
+	}
+
+	return nil
+}
+
+// Whether any filter is to be applied.
+func (f Filters) enabled() bool {
+	return f != Filters{}
+}
+
+// Reads an image from the input, and then applies the filters
+// (see `FilterImage`).
+func Filter(input io.Reader, filters Filters) (*vips.ImageRef, error) {
+	image, err := vips.LoadImage(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := FilterImage(image, filters); err != nil {
+		image.Close()
+		return nil, err
+	}
+
+	return image, nil
+}
+
+// Applies the filters on an image already loaded, in order:
+// adjustments, grayscale, blur and sharpening.
+func FilterImage(image *vips.ImageRef, filters Filters) error {
+	err := Adjust(image,
+		filters.Brightness, filters.Contrast, filters.Saturation)
+
+	if err == nil && filters.Grayscale {
+		err = Grayscale(image)
+	}
+
+	if err == nil && filters.Blur > 0 {
+		err = Blur(image, filters.Blur)
+	}
+
+	if err == nil && filters.Sharpen > 0 {
+		err = Sharpen(image, filters.Sharpen)
+	}
+
+	return err
+}
+
+// Blurs the image.
+//
+// - image: In-memory image reference
+// - sigma: Standard deviation of the gaussian (e.g. 5 for a strong blur)
+func Blur(image *vips.ImageRef, sigma float64) error {
+	blurred, err := vips.Gaussblur(image.Image(), sigma)
+
+	if err != nil {
+		return err
+	}
+
+	image.SetImage(blurred)
+
+	return nil
+}
+
+// Sharpens the image with an unsharp mask (e.g. after it's scaled down).
+//
+// - image: In-memory image reference
+// - sigma: Standard deviation of the mask (e.g. 0.5 for a light sharpening)
+func Sharpen(image *vips.ImageRef, sigma float64) error {
+	sharpened, err := vips.Sharpen(
+		image.Image(), vips.InputDouble("sigma", sigma))
+
+	if err != nil {
+		return err
+	}
+
+	image.SetImage(sharpened)
+
+	return nil
+}
+
+// Converts the image to grayscale (keeping the alpha channel if any).
+func Grayscale(image *vips.ImageRef) error {
+	gray, err := vips.Colourspace(image.Image(), vips.InterpretationBW)
+
+	if err != nil {
+		return err
+	}
+
+	image.SetImage(gray)
+
+	return nil
+}
+
+// Adjusts the brightness, the contrast and the saturation of the image.
+//
+// - image: In-memory image reference
+// - brightness: From -100 (black) to 100 (white); Ignored if 0.
+// - contrast: From -100 (flat gray) to 100 (doubled); Ignored if 0.
+// - saturation: From -100 (grayscale) to 100 (doubled); Ignored if 0.
+func Adjust(
+	image *vips.ImageRef,
+	brightness float64,
+	contrast float64,
+	saturation float64) error {
+
+	if brightness == 0 && contrast == 0 && saturation == 0 {
+		return nil
+	}
+
+	// 8 bits per band, so that the values can be mapped
+	rgb, err := vips.Colourspace(image.Image(), vips.InterpretationSRGB)
+
+	if err != nil {
+		return err
+	}
+
+	image.SetImage(rgb)
+
+	if brightness != 0 || contrast != 0 {
+		offset := brightness * 255 / 100
+		factor := (100 + contrast) / 100
+
+		level := func(v int) int {
+			return int(math.Round((float64(v)-128)*factor + 128 + offset))
+		}
+
+		err := mapBands(image, level, level, level)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if saturation == 0 {
+		return nil
+	}
+
+	// ---
+
+	hsv, err := vips.Colourspace(image.Image(), vips.InterpretationHSV)
+
+	if err != nil {
+		return err
+	}
+
+	image.SetImage(hsv)
+
+	factor := (100 + saturation) / 100
+
+	err = mapBands(image, nil, func(v int) int {
+		return int(math.Round(float64(v) * factor))
+	}, nil)
+
+	if err != nil {
+		return err
+	}
+
+	rgb, err = vips.Colourspace(image.Image(), vips.InterpretationSRGB)
+
+	if err != nil {
+		return err
+	}
+
+	image.SetImage(rgb)
+
+	return nil
+}
+
+// Maps the values of the first 3 bands (8 bits) of the image
+// using the given functions (nil to keep a band as is),
+// through a look-up table.
+//
+// The alpha band (4th one), if any, is kept as is.
+func mapBands(image *vips.ImageRef, mappings ...func(int) int) error {
+	alpha := image.Bands() > 3
+	table := makeLookupTable(alpha, mappings)
+
+	buf := new(bytes.Buffer)
+
+	if err := png.Encode(buf, table); err != nil {
+		return err
+	}
+
+	lut, err := vips.NewImageFromBuffer(buf.Bytes())
+
+	if err != nil {
+		return err
+	}
+
+	defer lut.Close()
+
+	mapped, err := vips.Maplut(image.Image(), lut.Image())
+
+	if err != nil {
+		return err
+	}
+
+	image.SetImage(mapped)
+
+	return nil
+}
+
+// Returns a 256x1 table, with the value of each band mapped
+// from its index (opaque unless `alpha`, so encoded with 3 bands).
+func makeLookupTable(alpha bool, mappings []func(int) int) *image.NRGBA {
+	table := image.NewNRGBA(image.Rect(0, 0, 256, 1))
+
+	for i := 0; i < 256; i++ {
+		bands := [4]uint8{}
+
+		for b := range bands {
+			v := i
+
+			if b < len(mappings) && mappings[b] != nil {
+				v = mappings[b](i)
+			} else if b == 3 && !alpha {
+				v = 255
+			}
+
+			bands[b] = uint8(maxInt(0, minInt(v, 255)))
+		}
+
+		table.SetNRGBA(i, 0, color.NRGBA{bands[0], bands[1], bands[2], bands[3]})
+	}
+
+	return table
+}
@@ -0,0 +1,79 @@
+package nuggan
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+)
+
+// Group of in-flight calls, so that concurrent calls for the same key
+// are collapsed in a single execution, whose result is shared.
+type flightGroup struct {
+	mutex sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// Executes the given function, unless a call for the same `key`
+// is already in flight, in which case its result is awaited.
+//
+// If the function panics, the panic is returned as an error
+// to all the callers.
+func (g *flightGroup) Do(
+	key string,
+	fn func() (interface{}, error)) (interface{}, error) {
+
+	g.mutex.Lock()
+
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+
+	if call, ok := g.calls[key]; ok {
+		g.mutex.Unlock()
+
+		<-call.done
+
+		return call.value, call.err
+	}
+
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+
+	g.mutex.Unlock()
+
+	defer func() {
+		g.mutex.Lock()
+		delete(g.calls, key)
+		g.mutex.Unlock()
+
+		close(call.done)
+	}()
+
+	call.value, call.err = recoverCall(fn)
+
+	return call.value, call.err
+}
+
+// Executes the function, recovering a panic as an error.
+func recoverCall(
+	fn func() (interface{}, error)) (value interface{}, err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("ERROR: Call panicked: %v\n%s", r, debug.Stack())
+
+			value = nil
+			err = errors.New(fmt.Sprintf("Call panicked: %v", r))
+		}
+	}()
+
+	return fn()
+}
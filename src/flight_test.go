@@ -0,0 +1,123 @@
+package nuggan
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroupCollapse(t *testing.T) {
+	group := &flightGroup{}
+	calls := int32(0)
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+
+	results := make([]interface{}, 10)
+
+	for i := range results {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			v, _ := group.Do("key", func() (interface{}, error) {
+				if atomic.AddInt32(&calls, 1) == 1 {
+					close(started)
+				}
+
+				<-release
+
+				return "result", nil
+			})
+
+			results[i] = v
+		}(i)
+	}
+
+	<-started
+	time.Sleep(10 * time.Millisecond) // let other calls wait
+	close(release)
+
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("Single execution expected: %d", calls)
+	}
+
+	for i, v := range results {
+		if v != "result" {
+			t.Errorf("Unexpected result #%d: %v", i, v)
+		}
+	}
+}
+
+func TestFlightGroupSequential(t *testing.T) {
+	group := &flightGroup{}
+	calls := 0
+
+	for i := 0; i < 2; i++ {
+		_, err := group.Do("key", func() (interface{}, error) {
+			calls++
+
+			return nil, errors.New("failure")
+		})
+
+		if err == nil || err.Error() != "failure" {
+			t.Errorf("Error expected: %v", err)
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("Completed call must not be shared: %d", calls)
+	}
+}
+
+func TestFlightGroupPanic(t *testing.T) {
+	group := &flightGroup{}
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+
+	errs := make([]error, 5)
+
+	for i := range errs {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			v, err := group.Do("key", func() (interface{}, error) {
+				close(started)
+				<-release
+
+				panic("failure")
+			})
+
+			if v != nil {
+				t.Errorf("No value expected: %v", v)
+			}
+
+			errs[i] = err
+		}(i)
+
+		if i == 0 {
+			<-started
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond) // let other calls wait
+	close(release)
+
+	wg.Wait()
+
+	for i, err := range errs {
+		if err == nil || err.Error() != "Call panicked: failure" {
+			t.Errorf("Panic expected as error #%d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package nuggan
+
+import (
+	"errors"
+	"fmt"
+	"github.com/davidbyttow/govips/pkg/vips"
+	"strconv"
+	"strings"
+)
+
+// Output formats, by extension
+var outputFormats = map[string]vips.ImageType{
+	"jpeg": vips.ImageTypeJPEG,
+	"jpg":  vips.ImageTypeJPEG,
+	"png":  vips.ImageTypePNG,
+	"webp": vips.ImageTypeWEBP,
+}
+
+// Splits the optional output format suffix (e.g. `.webp`)
+// from a base64Ref (as '.' is not a base64 character).
+//
+// Returns `vips.ImageTypeUnknown` if no format is specified,
+// meaning the source format is kept.
+func parseOutputFormat(ref string) (string, vips.ImageType, error) {
+	idx := strings.LastIndex(ref, ".")
+
+	if idx == -1 {
+		return ref, vips.ImageTypeUnknown, nil
+	}
+
+	ext := strings.ToLower(ref[idx+1:])
+
+	// No AVIF/HEIF encoder in the libvips binding (requires libvips >= 8.9)
+	if ext == "avif" {
+		return "", vips.ImageTypeUnknown, errors.New(
+			"Output format 'avif' is not supported by the libvips binding")
+	}
+
+	format, ok := outputFormats[ext]
+
+	if !ok {
+		return "", vips.ImageTypeUnknown, errors.New(fmt.Sprintf(
+			"Unsupported output format '%s': expected jpeg, png or webp",
+			ext))
+	}
+
+	return ref[:idx], format, nil
+}
+
+// Returns the first of the negotiable `formats` accepted
+// according the given `Accept` header value
+// (or `vips.ImageTypeUnknown` if none).
+//
+// Only explicit media types are considered (not `*/*` or `image/*`),
+// as most clients send wildcards whatever they can actually display.
+func negotiateFormat(formats []vips.ImageType, accept string) vips.ImageType {
+	if accept == "" {
+		return vips.ImageTypeUnknown
+	}
+
+	accepted := make(map[string]bool)
+
+	for _, r := range strings.Split(accept, ",") {
+		params := strings.Split(r, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		refused := false
+
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+
+			if strings.HasPrefix(p, "q=") {
+				q, err := strconv.ParseFloat(p[2:], 64)
+
+				refused = (err == nil && q <= 0)
+			}
+		}
+
+		if !refused {
+			accepted[mediaType] = true
+		}
+	}
+
+	for _, f := range formats {
+		if accepted[fmt.Sprintf("image/%s", vips.ImageTypes[f])] {
+			return f
+		}
+	}
+
+	return vips.ImageTypeUnknown
+}
@@ -0,0 +1,95 @@
+package nuggan
+
+import (
+	"testing"
+
+	"github.com/davidbyttow/govips/pkg/vips"
+)
+
+func TestParseOutputFormatNone(t *testing.T) {
+	ref, format, err := parseOutputFormat("_2_L3BvcHRvY2F0X3YyLnBuZw==")
+
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if ref != "_2_L3BvcHRvY2F0X3YyLnBuZw==" || format != vips.ImageTypeUnknown {
+		t.Errorf("Unexpected output format: %s, %v", ref, format)
+	}
+}
+
+func TestParseOutputFormatWebp(t *testing.T) {
+	ref, format, err := parseOutputFormat("_2_L3BvcHRvY2F0X3YyLnBuZw==.webp")
+
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if ref != "_2_L3BvcHRvY2F0X3YyLnBuZw==" || format != vips.ImageTypeWEBP {
+		t.Errorf("Unexpected output format: %s, %v", ref, format)
+	}
+}
+
+func TestParseOutputFormatJpg(t *testing.T) {
+	_, format, err := parseOutputFormat("_2_L3BvcHRvY2F0X3YyLnBuZw==.JPG")
+
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if format != vips.ImageTypeJPEG {
+		t.Errorf("Unexpected output format: %v", format)
+	}
+}
+
+func TestParseOutputFormatUnsupported(t *testing.T) {
+	_, _, err := parseOutputFormat("_2_L3BvcHRvY2F0X3YyLnBuZw==.bmp")
+
+	expected := "Unsupported output format 'bmp': expected jpeg, png or webp"
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error '%s': %v", expected, err)
+	}
+}
+
+var negotiable = []vips.ImageType{vips.ImageTypeWEBP, vips.ImageTypePNG}
+
+func TestNegotiateFormatWebp(t *testing.T) {
+	got := negotiateFormat(negotiable, "image/avif,image/webp,image/apng,image/*,*/*;q=0.8")
+
+	if got != vips.ImageTypeWEBP {
+		t.Errorf("Unexpected format: %v", got)
+	}
+}
+
+func TestNegotiateFormatPreference(t *testing.T) {
+	got := negotiateFormat(negotiable, "image/png, image/webp")
+
+	if got != vips.ImageTypeWEBP {
+		t.Errorf("Configured preference must be used: %v", got)
+	}
+}
+
+func TestNegotiateFormatWildcard(t *testing.T) {
+	got := negotiateFormat(negotiable, "image/*,*/*;q=0.8")
+
+	if got != vips.ImageTypeUnknown {
+		t.Errorf("Wildcard must not be negotiated: %v", got)
+	}
+}
+
+func TestNegotiateFormatRefused(t *testing.T) {
+	got := negotiateFormat(negotiable, "image/webp;q=0, image/png;q=0.5")
+
+	if got != vips.ImageTypePNG {
+		t.Errorf("Refused format must not be negotiated: %v", got)
+	}
+}
+
+func TestNegotiateFormatNoAccept(t *testing.T) {
+	got := negotiateFormat(negotiable, "")
+
+	if got != vips.ImageTypeUnknown {
+		t.Errorf("Unexpected format: %v", got)
+	}
+}
@@ -0,0 +1,178 @@
+package nuggan
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// Networks which cannot be fetched unless from a configured group
+var defaultDeniedNetworks = []string{
+	"0.0.0.0/8",      // "this" network
+	"10.0.0.0/8",     // private
+	"100.64.0.0/10",  // carrier-grade NAT
+	"127.0.0.0/8",    // loopback
+	"169.254.0.0/16", // link-local (e.g. cloud metadata)
+	"172.16.0.0/12",  // private
+	"192.168.0.0/16", // private
+	"198.18.0.0/15",  // benchmarking
+	"224.0.0.0/4",    // multicast
+	"240.0.0.0/4",    // reserved (including broadcast)
+	"::/128",         // unspecified
+	"::1/128",        // loopback
+	"64:ff9b::/96",   // NAT64 (IPv4 embedded)
+	"fc00::/7",       // unique local
+	"fe80::/10",      // link-local
+	"ff00::/8",       // multicast
+}
+
+// Error raised when a media URL is refused by the origin guard
+type forbiddenOriginError struct {
+	msg string
+}
+
+func (e *forbiddenOriginError) Error() string {
+	return e.msg
+}
+
+// Guard against Server-Side Request Forgery, checking the URLs
+// which are not resolved from a configured group (in non-strict mode).
+type originGuard struct {
+	allowedHosts []string
+	deniedHosts  []string
+	networks     []*net.IPNet
+}
+
+func newOriginGuard(conf OriginConfig) *originGuard {
+	cidrs := append(
+		append([]string{}, defaultDeniedNetworks...),
+		conf.DeniedNetworks...)
+
+	networks := []*net.IPNet{}
+
+	for _, c := range cidrs {
+		n, err := parseNetwork(c)
+
+		if err != nil {
+			log.Printf("WARNING: %s\n", err)
+			continue
+		}
+
+		networks = append(networks, n)
+	}
+
+	return &originGuard{
+		allowedHosts: normalizeHosts(conf.AllowedHosts),
+		deniedHosts:  normalizeHosts(conf.DeniedHosts),
+		networks:     networks,
+	}
+}
+
+// Checks the scheme & host of the given URL, and its resolved addresses.
+func (g *originGuard) CheckUrl(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return &forbiddenOriginError{fmt.Sprintf(
+			"Media scheme is not allowed: %s", u.Scheme)}
+	}
+
+	host := strings.ToLower(u.Hostname())
+
+	if len(g.allowedHosts) > 0 && !matchHost(g.allowedHosts, host) {
+		return &forbiddenOriginError{fmt.Sprintf(
+			"Media host is not allowed: %s", host)}
+	}
+
+	if matchHost(g.deniedHosts, host) {
+		return &forbiddenOriginError{fmt.Sprintf(
+			"Media host is denied: %s", host)}
+	}
+
+	ips, err := net.LookupIP(host)
+
+	if err != nil {
+		return err
+	}
+
+	for _, ip := range ips {
+		if err := g.CheckIP(ip); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *originGuard) CheckIP(ip net.IP) error {
+	for _, n := range g.networks {
+		if n.Contains(ip) {
+			return &forbiddenOriginError{fmt.Sprintf(
+				"Media address is denied: %s", ip)}
+		}
+	}
+
+	return nil
+}
+
+// Checks the address actually dialed, after resolution
+// (see `net.Dialer.Control`), so that the DNS cannot be rebound.
+//
+// Not applicable when a proxy is configured, as the proxy is dialed
+// (the URL addresses are then only checked before the request).
+func (g *originGuard) checkDial(
+	network string,
+	address string,
+	_ syscall.RawConn) error {
+
+	host, _, err := net.SplitHostPort(address)
+
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+
+	if ip == nil {
+		return &forbiddenOriginError{fmt.Sprintf(
+			"Unresolved media address: %s", address)}
+	}
+
+	return g.CheckIP(ip)
+}
+
+// ---
+
+func parseNetwork(cidr string) (*net.IPNet, error) {
+	_, n, err := net.ParseCIDR(cidr)
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf(
+			"Invalid denied network: %s", cidr))
+	}
+
+	return n, nil
+}
+
+func normalizeHosts(hosts []string) []string {
+	normalized := make([]string, len(hosts))
+
+	for i, h := range hosts {
+		normalized[i] = strings.ToLower(strings.TrimPrefix(h, "."))
+	}
+
+	return normalized
+}
+
+// Checks whether the host is one of the given ones, or a subdomain.
+func matchHost(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+
+	return false
+}
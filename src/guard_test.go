@@ -0,0 +1,108 @@
+package nuggan
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestGuardDeniedAddresses(t *testing.T) {
+	guard := newOriginGuard(OriginConfig{
+		DeniedNetworks: []string{"203.0.113.0/24"},
+	})
+
+	for _, a := range []string{
+		"127.0.0.1", "10.1.2.3", "172.20.0.1", "192.168.1.1",
+		"169.254.169.254", "::1", "fe80::1", "fd00::1",
+		"::ffff:127.0.0.1", "203.0.113.10", "100.64.0.1", "198.19.0.1",
+		"240.0.0.1", "255.255.255.255", "64:ff9b::7f00:1",
+	} {
+		err := guard.CheckIP(net.ParseIP(a))
+
+		if _, ok := err.(*forbiddenOriginError); !ok {
+			t.Errorf("Address %s must be denied: %v", a, err)
+		}
+	}
+
+	for _, a := range []string{"93.184.216.34", "2606:2800:220:1::"} {
+		if err := guard.CheckIP(net.ParseIP(a)); err != nil {
+			t.Errorf("Address %s must be allowed: %v", a, err)
+		}
+	}
+}
+
+func TestGuardHosts(t *testing.T) {
+	guard := newOriginGuard(OriginConfig{
+		AllowedHosts: []string{"example.com", "127.0.0.1"},
+		DeniedHosts:  []string{"private.example.com"},
+	})
+
+	for _, u := range []string{
+		"ftp://example.com/image.png",
+		"http://other.org/image.png",
+		"http://cdn.private.example.com/image.png",
+		"http://127.0.0.1/image.png",
+	} {
+		ref, _ := url.Parse(u)
+		err := guard.CheckUrl(ref)
+
+		if _, ok := err.(*forbiddenOriginError); !ok {
+			t.Errorf("URL %s must be refused: %v", u, err)
+		}
+	}
+
+	if !matchHost(guard.allowedHosts, "cdn.example.com") {
+		t.Error("Subdomain must match")
+	}
+
+	if matchHost(guard.allowedHosts, "notexample.com") {
+		t.Error("Unrelated domain must not match")
+	}
+}
+
+func TestGuardedFetch(t *testing.T) {
+	origin := testOrigin()
+
+	defer origin.Close()
+
+	conf := OriginConfig{}
+	fetcher := newOriginFetcher(conf, newOriginGuard(conf))
+
+	_, err := fetcher.Fetch([]string{origin.URL + "/image"}, http.Header{})
+
+	if _, ok := err.(*forbiddenOriginError); !ok {
+		t.Errorf("Loopback origin must be refused: %v", err)
+	}
+}
+
+func TestGuardedRedirect(t *testing.T) {
+	origin := testOrigin()
+
+	defer origin.Close()
+
+	// Allowed front, redirecting to another host
+	front := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, origin.URL+"/image", http.StatusFound)
+		}))
+
+	defer front.Close()
+
+	frontUrl, _ := url.Parse(front.URL)
+	guard := newOriginGuard(OriginConfig{
+		AllowedHosts: []string{"localhost"},
+	})
+
+	guard.networks = nil // so that loopback front can be tested
+
+	fetcher := newOriginFetcher(OriginConfig{}, guard)
+	frontRef := "http://localhost:" + frontUrl.Port() + "/"
+
+	_, err := fetcher.Fetch([]string{frontRef}, http.Header{})
+
+	if !isOriginRefusal(err) {
+		t.Errorf("Redirect to %s must be refused: %v", origin.URL, err)
+	}
+}
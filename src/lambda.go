@@ -7,6 +7,8 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/davidbyttow/govips/pkg/vips"
 	"log"
+	"net/http"
+	"net/url"
 	"strings"
 )
 
@@ -26,8 +28,10 @@ func lambdaHandler(conf Config) func(events.APIGatewayProxyRequest) (events.APIG
 		if strings.HasPrefix(event.Path, urlPrefix) {
 			request := ImageRequest{
 				Path:    event.Path,
+				Query:   lambdaQuery(event),
 				Method:  event.HTTPMethod,
 				Referer: lambdaReferer(event),
+				Header:  lambdaHeader(event),
 			}
 
 			log.Printf("Image request: %v\n", request)
@@ -86,3 +90,27 @@ func lambdaReferer(event events.APIGatewayProxyRequest) ImageReferer {
 		}
 	}
 }
+
+func lambdaQuery(event events.APIGatewayProxyRequest) url.Values {
+	if len(event.MultiValueQueryStringParameters) > 0 {
+		return url.Values(event.MultiValueQueryStringParameters)
+	}
+
+	query := make(url.Values)
+
+	for k, v := range event.QueryStringParameters {
+		query.Set(k, v)
+	}
+
+	return query
+}
+
+func lambdaHeader(event events.APIGatewayProxyRequest) http.Header {
+	header := make(http.Header)
+
+	for k, v := range event.Headers {
+		header.Set(k, v)
+	}
+
+	return header
+}
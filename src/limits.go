@@ -0,0 +1,243 @@
+package nuggan
+
+import (
+	"errors"
+	"fmt"
+	"github.com/davidbyttow/govips/pkg/vips"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Limits of the source images and of the requested output
+type LimitsConfig struct {
+	MaxSourcePixels int64    // width x height, unlimited if 0
+	MaxSourceSize   int64    // in bytes, unlimited if 0
+	MaxOutputWidth  int      // unlimited if 0
+	MaxOutputHeight int      // unlimited if 0
+	AllowedSizes    []string // if any, only these resize 'WxH' ('-' if none)
+	MaxUpscale      float64  // if requested; defaulted to 2
+	MaxDpr          float64  // device pixel ratio; defaulted to 3
+}
+
+// Default maximum enlargement factor
+const defaultMaxUpscale = 2.0
+
+// Default maximum device pixel ratio
+const defaultMaxDpr = 3.0
+
+// Error raised when a transformation exceeds the configured limits
+type LimitError struct {
+	status int // 400 for the output, 413 for the source
+	msg    string
+}
+
+func (e *LimitError) Error() string {
+	return e.msg
+}
+
+// Returns the HTTP status corresponding to the error:
+// 400 if the requested output exceeds the limits,
+// 413 if the source image does.
+func (e *LimitError) StatusCode() int {
+	return e.status
+}
+
+// Parses an allowed size (e.g. `320x200`, `320x-` or `-x-`).
+func parseSize(size string) (int, int, error) {
+	parts := strings.Split(strings.TrimSpace(size), "x")
+
+	if len(parts) != 2 {
+		return 0, 0, errors.New(fmt.Sprintf(
+			"Invalid allowed size '%s': expected WxH", size))
+	}
+
+	dims := []int{-1, -1}
+
+	for i, p := range parts {
+		if p == "-" {
+			continue
+		}
+
+		d, err := strconv.Atoi(p)
+
+		if err != nil || d <= 0 {
+			return 0, 0, errors.New(fmt.Sprintf(
+				"Invalid allowed size '%s': expected WxH", size))
+		}
+
+		dims[i] = d
+	}
+
+	return dims[0], dims[1], nil
+}
+
+// Checks the requested crop & resize dimensions
+// (-1 if not specified) against the limits.
+// The allowed sizes are checked before the device pixel ratio is applied.
+func checkOutputSize(
+	limits LimitsConfig,
+	cropW int,
+	cropH int,
+	resizeW int,
+	resizeH int,
+	dpr float64) error {
+
+	// Output dimensions if not resized
+	outW := cropW
+	outH := cropH
+
+	if resizeW > 0 {
+		outW = applyDpr(resizeW, dpr)
+		outH = applyDpr(resizeH, dpr)
+	}
+
+	if err := checkOutputDimensions(limits, outW, outH); err != nil {
+		return err
+	}
+
+	if len(limits.AllowedSizes) == 0 {
+		return nil
+	}
+
+	// ---
+
+	w, h := resizeW, resizeH
+
+	if w <= 0 {
+		w, h = -1, -1
+	} else if h <= 0 {
+		h = -1
+	}
+
+	for _, s := range limits.AllowedSizes {
+		aw, ah, err := parseSize(s)
+
+		if err == nil && aw == w && ah == h {
+			return nil
+		}
+	}
+
+	return &LimitError{400, fmt.Sprintf(
+		"Resize %sx%s is not allowed", sizeRepr(w), sizeRepr(h))}
+}
+
+// Checks the output dimensions (-1 if not known) against the limits.
+//
+// Once the source is read, the effective dimensions must be checked
+// (e.g. the height derived from the aspect ratio when only a width is requested).
+func checkOutputDimensions(limits LimitsConfig, width int, height int) error {
+	if limits.MaxOutputWidth > 0 && width > limits.MaxOutputWidth {
+		return &LimitError{400, fmt.Sprintf(
+			"Output width %d exceeds the maximum: %d",
+			width, limits.MaxOutputWidth)}
+	}
+
+	if limits.MaxOutputHeight > 0 && height > limits.MaxOutputHeight {
+		return &LimitError{400, fmt.Sprintf(
+			"Output height %d exceeds the maximum: %d",
+			height, limits.MaxOutputHeight)}
+	}
+
+	return nil
+}
+
+// Checks the source dimensions (read from the image header),
+// before it's decoded.
+func checkSourceSize(limits LimitsConfig, image *vips.ImageRef) error {
+	pixels := int64(image.Width()) * int64(image.Height())
+
+	if limits.MaxSourcePixels > 0 && pixels > limits.MaxSourcePixels {
+		return &LimitError{413, fmt.Sprintf(
+			"Source image %dx%d exceeds the maximum pixels: %d",
+			image.Width(), image.Height(), limits.MaxSourcePixels)}
+	}
+
+	return nil
+}
+
+// Returns the maximum scale for a resize
+// (1 if the enlargement is not requested).
+func maxScale(limits LimitsConfig, upscale bool) float64 {
+	if !upscale {
+		return 1
+	}
+
+	if limits.MaxUpscale <= 0 {
+		return defaultMaxUpscale
+	}
+
+	return limits.MaxUpscale
+}
+
+// Returns the requested device pixel ratio, capped by the limits
+// (or 1 if none).
+func limitedDpr(limits LimitsConfig, dpr float64) float64 {
+	max := limits.MaxDpr
+
+	if max <= 0 {
+		max = defaultMaxDpr
+	}
+
+	if dpr <= 0 {
+		return 1
+	} else if dpr > max {
+		return max
+	}
+
+	return dpr
+}
+
+// Returns the device pixel ratio, capped so that the source image
+// is not enlarged for it (unless `upscale`).
+func sourceDpr(
+	dpr float64,
+	upscale bool,
+	sourceW int,
+	sourceH int,
+	resizeW int,
+	resizeH int) float64 {
+
+	if resizeW <= 0 {
+		return 1 // not resized
+	} else if dpr <= 1 || upscale {
+		return dpr
+	}
+
+	max := float64(sourceW) / float64(resizeW)
+
+	if resizeH > 0 {
+		max = math.Min(max, float64(sourceH)/float64(resizeH))
+	}
+
+	return math.Max(1, math.Min(dpr, max))
+}
+
+// Returns the dimension for the device pixel ratio
+// (-1 if none, unchanged if no ratio).
+func applyDpr(dim int, dpr float64) int {
+	if dim <= 0 || dpr <= 0 {
+		return dim
+	}
+
+	return int(math.Round(float64(dim) * dpr))
+}
+
+// Returns the origin settings with the body size capped by the limits.
+func limitedOrigin(conf OriginConfig, limits LimitsConfig) OriginConfig {
+	max := limits.MaxSourceSize
+
+	if max > 0 && (conf.MaxBodySize <= 0 || max < conf.MaxBodySize) {
+		conf.MaxBodySize = max
+	}
+
+	return conf
+}
+
+func sizeRepr(d int) string {
+	if d <= 0 {
+		return "-"
+	}
+
+	return strconv.Itoa(d)
+}
@@ -0,0 +1,144 @@
+package nuggan
+
+import (
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	for size, expected := range map[string][2]int{
+		"320x200": {320, 200},
+		"320x-":   {320, -1},
+		"-x-":     {-1, -1},
+	} {
+		w, h, err := parseSize(size)
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if w != expected[0] || h != expected[1] {
+			t.Errorf("%s: %dx%d != %v", size, w, h, expected)
+		}
+	}
+
+	for _, size := range []string{"320", "0x200", "ax-", "320x200x1"} {
+		if _, _, err := parseSize(size); err == nil {
+			t.Errorf("Invalid size must be refused: %s", size)
+		}
+	}
+}
+
+func TestCheckOutputSize(t *testing.T) {
+	limits := LimitsConfig{
+		MaxOutputWidth:  1024,
+		MaxOutputHeight: 768,
+	}
+
+	if err := checkOutputSize(limits, -1, -1, 640, 480, 1); err != nil {
+		t.Errorf("Size must be accepted: %v", err)
+	}
+
+	for _, dims := range [][4]int{
+		{-1, -1, 2048, -1}, // resize width
+		{-1, -1, 640, 800}, // resize height
+		{2000, -1, -1, -1}, // crop width
+	} {
+		err := checkOutputSize(limits, dims[0], dims[1], dims[2], dims[3], 1)
+
+		if e, ok := err.(*LimitError); !ok || e.status != 400 {
+			t.Errorf("Size must be refused: %v (%v)", dims, err)
+		}
+	}
+}
+
+func TestCheckAllowedSizes(t *testing.T) {
+	limits := LimitsConfig{
+		AllowedSizes: []string{"320x-", "640x480"},
+	}
+
+	for _, dims := range [][2]int{{320, -1}, {640, 480}} {
+		if err := checkOutputSize(limits, -1, -1, dims[0], dims[1], 1); err != nil {
+			t.Errorf("Size must be allowed: %v", err)
+		}
+	}
+
+	for _, dims := range [][2]int{{320, 200}, {640, -1}, {-1, -1}} {
+		err := checkOutputSize(limits, -1, -1, dims[0], dims[1], 1)
+
+		if e, ok := err.(*LimitError); !ok || e.status != 400 {
+			t.Errorf("Size must be refused: %v (%v)", dims, err)
+		}
+	}
+}
+
+func TestLimitedOrigin(t *testing.T) {
+	limits := LimitsConfig{MaxSourceSize: 1024}
+
+	if got := limitedOrigin(OriginConfig{}, limits); got.MaxBodySize != 1024 {
+		t.Errorf("Unexpected max body size: %d", got.MaxBodySize)
+	}
+
+	origin := OriginConfig{MaxBodySize: 512}
+
+	if got := limitedOrigin(origin, limits); got.MaxBodySize != 512 {
+		t.Errorf("Unexpected max body size: %d", got.MaxBodySize)
+	}
+}
+
+func TestMaxScale(t *testing.T) {
+	if s := maxScale(LimitsConfig{MaxUpscale: 3}, false); s != 1 {
+		t.Errorf("No enlargement expected: %f", s)
+	}
+
+	if s := maxScale(LimitsConfig{}, true); s != defaultMaxUpscale {
+		t.Errorf("Default enlargement expected: %f", s)
+	}
+
+	if s := maxScale(LimitsConfig{MaxUpscale: 3}, true); s != 3 {
+		t.Errorf("Configured enlargement expected: %f", s)
+	}
+}
+
+func TestLimitedDpr(t *testing.T) {
+	if dpr := limitedDpr(LimitsConfig{}, 4); dpr != defaultMaxDpr {
+		t.Errorf("Default max DPR expected: %f", dpr)
+	}
+
+	if dpr := limitedDpr(LimitsConfig{MaxDpr: 2}, 1.5); dpr != 1.5 {
+		t.Errorf("Unexpected DPR: %f", dpr)
+	}
+}
+
+func TestSourceDpr(t *testing.T) {
+	// 300px source for 200px: 2x capped to 1.5x
+	if dpr := sourceDpr(2, false, 300, 600, 200, -1); dpr != 1.5 {
+		t.Errorf("DPR capped by source expected: %f", dpr)
+	}
+
+	if dpr := sourceDpr(2, true, 300, 600, 200, -1); dpr != 2 {
+		t.Errorf("DPR not capped if upscaled: %f", dpr)
+	}
+
+	if dpr := sourceDpr(2, false, 100, 100, 200, -1); dpr != 1 {
+		t.Errorf("DPR 1 expected for smaller source: %f", dpr)
+	}
+
+	if dpr := sourceDpr(2, false, 1000, 1000, -1, -1); dpr != 1 {
+		t.Errorf("DPR 1 expected without resize: %f", dpr)
+	}
+}
+
+func TestOutputSizeWithDpr(t *testing.T) {
+	limits := LimitsConfig{
+		MaxOutputWidth: 1024,
+		AllowedSizes:   []string{"640x-"},
+	}
+
+	if err := checkOutputSize(limits, -1, -1, 640, -1, 1.5); err != nil {
+		t.Errorf("Size must be accepted: %v", err)
+	}
+
+	if err := checkOutputSize(limits, -1, -1, 640, -1, 2); err == nil {
+		t.Error("Size must be refused")
+	}
+}
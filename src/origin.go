@@ -0,0 +1,335 @@
+package nuggan
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default settings of the origin client
+const (
+	defaultOriginConnectTimeout = 10 * time.Second
+	defaultOriginReadTimeout    = 60 * time.Second
+	defaultOriginMaxRedirects   = 10
+	defaultOriginUserAgent      = "nuggan"
+	defaultOriginMaxIdleConns   = 100
+	defaultOriginIdleTimeout    = 90 * time.Second
+)
+
+// Response fetched from the origin, with the whole body
+// (so that it can be shared by concurrent requests).
+type originResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Error raised when the origin response exceeds the maximum body size
+type originTooLargeError struct {
+	url     string
+	maxSize int64
+}
+
+func (e *originTooLargeError) Error() string {
+	return fmt.Sprintf("Media '%s' exceeds the maximum size: %d bytes",
+		e.url, e.maxSize)
+}
+
+type originFetcher struct {
+	client      *http.Client
+	guard       *originGuard
+	userAgent   string
+	maxBodySize int64
+	fetches     flightGroup
+}
+
+// - conf: Client settings
+// - guard: Checks for the fetched URLs (or nil if trusted)
+func newOriginFetcher(conf OriginConfig, guard *originGuard) *originFetcher {
+	connectTimeout := conf.ConnectTimeout
+
+	if connectTimeout <= 0 {
+		connectTimeout = defaultOriginConnectTimeout
+	}
+
+	readTimeout := conf.ReadTimeout
+
+	if readTimeout <= 0 {
+		readTimeout = defaultOriginReadTimeout
+	}
+
+	maxRedirects := conf.MaxRedirects
+
+	if maxRedirects == 0 {
+		maxRedirects = defaultOriginMaxRedirects
+	}
+
+	userAgent := conf.UserAgent
+
+	if userAgent == "" {
+		userAgent = defaultOriginUserAgent
+	}
+
+	maxIdleConns := conf.MaxIdleConns
+
+	if maxIdleConns <= 0 {
+		maxIdleConns = defaultOriginMaxIdleConns
+	}
+
+	idleConnTimeout := conf.IdleConnTimeout
+
+	if idleConnTimeout <= 0 {
+		idleConnTimeout = defaultOriginIdleTimeout
+	}
+
+	proxy := http.ProxyFromEnvironment
+
+	if conf.Proxy != "" {
+		// Already validated by LoadConfig
+		proxyUrl, _ := url.Parse(conf.Proxy)
+		proxy = http.ProxyURL(proxyUrl)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	if guard != nil {
+		if conf.Proxy == "" {
+			// Direct connection, checked once resolved
+			proxy = nil
+			dialer.Control = guard.checkDial
+		}
+	}
+
+	transport := &http.Transport{
+		Proxy:               proxy,
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: connectTimeout,
+		MaxIdleConns:        maxIdleConns,
+		MaxIdleConnsPerHost: conf.MaxIdleConnsPerHost,
+		IdleConnTimeout:     idleConnTimeout,
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   readTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return errors.New(fmt.Sprintf(
+					"Too many redirects for '%s'", via[0].URL))
+			}
+
+			if guard != nil {
+				return guard.CheckUrl(req.URL)
+			}
+
+			return nil
+		},
+	}
+
+	return &originFetcher{
+		client:      client,
+		guard:       guard,
+		userAgent:   userAgent,
+		maxBodySize: conf.MaxBodySize,
+	}
+}
+
+// Fetches the requested media from the first available of the given
+// alternative URLs (in order), trying the next one on connection error,
+// timeout or server error; concurrent identical requests are collapsed.
+func (f *originFetcher) Fetch(
+	urls []string,
+	header http.Header) (*originResponse, error) {
+
+	var resp *originResponse = nil
+	var err error = nil
+
+	for i, u := range urls {
+		resp, err = f.fetchUrl(u, header)
+
+		if isOriginRefusal(err) {
+			return nil, err
+		}
+
+		if err == nil && resp.StatusCode < 500 {
+			return resp, nil
+		}
+
+		if i < len(urls)-1 {
+			if err != nil {
+				log.Printf("WARNING: Fails to fetch '%s', trying next URL: %s\n", u, err)
+			} else {
+				log.Printf("WARNING: Fails to fetch '%s', trying next URL: %d\n", u, resp.StatusCode)
+			}
+		}
+	}
+
+	return resp, err
+}
+
+func (f *originFetcher) fetchUrl(
+	mediaUrl string,
+	header http.Header) (*originResponse, error) {
+
+	key := mediaUrl
+
+	for _, name := range []string{"If-None-Match", "If-Modified-Since"} {
+		key = key + "\n" + strings.Join(header[name], ",")
+	}
+
+	v, err := f.fetches.Do(key, func() (interface{}, error) {
+		req, err := http.NewRequest("GET", mediaUrl, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for name, vs := range header {
+			req.Header[name] = vs
+		}
+
+		return f.fetch(req)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*originResponse), nil
+}
+
+func (f *originFetcher) fetch(req *http.Request) (*originResponse, error) {
+	if f.guard != nil {
+		if err := f.guard.CheckUrl(req.URL); err != nil {
+			return nil, err
+		}
+	}
+
+	req.Header.Set("User-Agent", f.userAgent)
+
+	resp, err := f.client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var body io.Reader = resp.Body
+
+	if f.maxBodySize > 0 {
+		if resp.ContentLength > f.maxBodySize {
+			return nil, &originTooLargeError{req.URL.String(), f.maxBodySize}
+		}
+
+		body = io.LimitReader(body, f.maxBodySize+1)
+	}
+
+	data, err := ioutil.ReadAll(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if f.maxBodySize > 0 && int64(len(data)) > f.maxBodySize {
+		return nil, &originTooLargeError{req.URL.String(), f.maxBodySize}
+	}
+
+	return &originResponse{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       data,
+	}, nil
+}
+
+// Checks whether the error is due to the media itself
+// (rather than to its origin availability).
+func isOriginRefusal(err error) bool {
+	var tooLarge *originTooLargeError
+	var denied *forbiddenOriginError
+
+	return errors.As(err, &tooLarge) || errors.As(err, &denied)
+}
+
+// Base URL selection among the alternatives of a group
+const (
+	selectFirst      = "first"
+	selectRoundRobin = "round-robin"
+	selectRandom     = "random"
+)
+
+// Orders the alternative URLs of a media, according the selection mode
+// (the first URL being the preferred one, the next ones the failovers).
+type mirrorSelector struct {
+	mode     string
+	mutex    sync.Mutex
+	counters map[int]int // by group index
+}
+
+func newMirrorSelector(mode string) *mirrorSelector {
+	return &mirrorSelector{
+		mode:     mode,
+		counters: make(map[int]int),
+	}
+}
+
+// Orders the URLs of a media from the given group
+// (index from `DecodeMediaUrls`), so that round-robin is shared
+// by all the media of the same group.
+func (s *mirrorSelector) Order(group int, urls []string) []string {
+	if len(urls) < 2 {
+		return urls
+	}
+
+	start := 0
+
+	if s.mode == selectRoundRobin {
+		s.mutex.Lock()
+
+		start = s.counters[group]
+		s.counters[group] = (start + 1) % len(urls)
+
+		s.mutex.Unlock()
+	} else if s.mode == selectRandom {
+		start = rand.Intn(len(urls))
+	}
+
+	return append(append([]string{}, urls[start:]...), urls[:start]...)
+}
+
+// ---
+
+// Fetches a media from the given URL, using an origin client
+// configured according `conf`.
+func FetchMedia(conf OriginConfig, mediaUrl string) ([]byte, error) {
+	req, err := http.NewRequest("GET", mediaUrl, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := newOriginFetcher(conf, nil).fetch(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New(fmt.Sprintf(
+			"Unexpected status: %d", resp.StatusCode))
+	}
+
+	return resp.Body, nil
+}
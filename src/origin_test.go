@@ -0,0 +1,214 @@
+package nuggan
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testOrigin() *httptest.Server {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "image from %s", r.Header.Get("User-Agent"))
+	})
+
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 1024))
+	})
+
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/image", http.StatusFound)
+	})
+
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func TestFetchMedia(t *testing.T) {
+	origin := testOrigin()
+
+	defer origin.Close()
+
+	got, err := FetchMedia(OriginConfig{UserAgent: "test"}, origin.URL+"/image")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if string(got) != "image from test" {
+		t.Errorf("Unexpected body: %s", got)
+	}
+}
+
+func TestFetchMediaTooLarge(t *testing.T) {
+	origin := testOrigin()
+
+	defer origin.Close()
+
+	_, err := FetchMedia(OriginConfig{MaxBodySize: 512}, origin.URL+"/large")
+
+	if _, ok := err.(*originTooLargeError); !ok {
+		t.Errorf("Too large error expected: %v", err)
+	}
+}
+
+func TestFetchMediaRedirect(t *testing.T) {
+	origin := testOrigin()
+
+	defer origin.Close()
+
+	got, err := FetchMedia(OriginConfig{}, origin.URL+"/redirect")
+
+	if err != nil || !strings.HasPrefix(string(got), "image from") {
+		t.Errorf("Redirect must be followed: %v", err)
+	}
+
+	_, err = FetchMedia(OriginConfig{MaxRedirects: -1}, origin.URL+"/redirect")
+
+	if err == nil || !strings.Contains(err.Error(), "Too many redirects") {
+		t.Errorf("Redirect must be refused: %v", err)
+	}
+}
+
+func TestFetchMediaTimeout(t *testing.T) {
+	origin := testOrigin()
+
+	defer origin.Close()
+
+	conf := OriginConfig{ReadTimeout: 50 * time.Millisecond}
+
+	if _, err := FetchMedia(conf, origin.URL+"/slow"); err == nil {
+		t.Error("Timeout expected")
+	}
+}
+
+func TestOriginFetchFailover(t *testing.T) {
+	origin := testOrigin()
+
+	defer origin.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(503)
+		}))
+
+	defer failing.Close()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close() // connection refused
+
+	fetcher := newOriginFetcher(OriginConfig{UserAgent: "test"}, nil)
+
+	got, err := fetcher.Fetch([]string{
+		down.URL + "/image",
+		failing.URL + "/image",
+		origin.URL + "/image",
+	}, http.Header{})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if got.StatusCode != 200 || string(got.Body) != "image from test" {
+		t.Errorf("Unexpected response: %d %s", got.StatusCode, got.Body)
+	}
+}
+
+func TestOriginFetchNoFailoverOnNotFound(t *testing.T) {
+	origin := testOrigin()
+
+	defer origin.Close()
+
+	fetcher := newOriginFetcher(OriginConfig{}, nil)
+
+	got, err := fetcher.Fetch([]string{
+		origin.URL + "/missing",
+		origin.URL + "/image",
+	}, http.Header{})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if got.StatusCode != 404 {
+		t.Errorf("Not found expected: %d", got.StatusCode)
+	}
+}
+
+func TestMirrorSelectorFirst(t *testing.T) {
+	urls := []string{"a", "b", "c"}
+	selector := newMirrorSelector("")
+
+	for i := 0; i < 2; i++ {
+		if got := selector.Order(0, urls); !reflect.DeepEqual(got, urls) {
+			t.Errorf("Unexpected order: %v", got)
+		}
+	}
+}
+
+func TestMirrorSelectorRoundRobin(t *testing.T) {
+	urls := []string{"a", "b", "c"}
+	selector := newMirrorSelector(selectRoundRobin)
+
+	expected := [][]string{
+		{"a", "b", "c"},
+		{"b", "c", "a"},
+		{"c", "a", "b"},
+		{"a", "b", "c"},
+	}
+
+	for _, e := range expected {
+		if got := selector.Order(0, urls); !reflect.DeepEqual(got, e) {
+			t.Errorf("%v != %v", got, e)
+		}
+	}
+}
+
+func TestMirrorSelectorRoundRobinByGroup(t *testing.T) {
+	decode := DecodeMediaUrls(config1)
+	selector := newMirrorSelector(selectRoundRobin)
+
+	var firsts []string
+
+	for _, path := range []string{"/octicons/1.png", "/octicons/2.png"} {
+		group, urls, err := decode(EncodeMediaUrl(config1)(
+			"https://cdn0.iconfinder.com/data/icons" + path))
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		got := selector.Order(group, urls)
+		firsts = append(firsts, strings.TrimSuffix(got[0], path))
+	}
+
+	expected := []string{
+		"https://cdn0.iconfinder.com/data/icons",
+		"https://cdn1.iconfinder.com/data/icons",
+	}
+
+	if !reflect.DeepEqual(firsts, expected) {
+		t.Errorf("Mirrors must alternate within the group: %v", firsts)
+	}
+
+	if n := len(selector.counters); n != 1 {
+		t.Errorf("A single counter expected for the group: %d", n)
+	}
+}
+
+func TestMirrorSelectorRandom(t *testing.T) {
+	urls := []string{"a", "b", "c"}
+	got := newMirrorSelector(selectRandom).Order(0, urls)
+
+	if len(got) != 3 {
+		t.Errorf("All URLs must be kept as failovers: %v", got)
+	}
+}
@@ -0,0 +1,370 @@
+package nuggan
+
+import (
+	"encoding/base64"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Optional parameters of a transformation,
+// specified as a path segment (e.g. `fit:cover`).
+type transformParams struct {
+	Fit        Fit
+	Gravity    Gravity // if specified, crop x/y are ignored
+	Dpr        float64 // device pixel ratio, 0 if none
+	Background string  // hex RGB or RGBA, lower case
+	Rotate     int     // clockwise, in degrees
+	FlipH      bool
+	FlipV      bool
+	Filters    Filters
+	Quality    int // lossy encoding quality, 0 if none
+
+	Watermark *Watermark   // without image, nil if none
+	Text      *TextOverlay // nil if none
+}
+
+var fitModes = map[string]Fit{
+	string(FitInside):  FitInside,
+	string(FitOutside): FitOutside,
+	string(FitCover):   FitCover,
+	string(FitContain): FitContain,
+	string(FitFill):    FitFill,
+}
+
+var gravities = map[string]Gravity{
+	string(GravityCenter):    GravityCenter,
+	string(GravityNorth):     GravityNorth,
+	string(GravityNorthEast): GravityNorthEast,
+	string(GravityEast):      GravityEast,
+	string(GravitySouthEast): GravitySouthEast,
+	string(GravitySouth):     GravitySouth,
+	string(GravitySouthWest): GravitySouthWest,
+	string(GravityWest):      GravityWest,
+	string(GravityNorthWest): GravityNorthWest,
+	string(GravityAttention): GravityAttention,
+	string(GravityEntropy):   GravityEntropy,
+	"smart":                  GravityAttention,
+}
+
+// Parses the comma separated `name:value` parameters.
+func parseParams(segment string) (transformParams, error) {
+	params := transformParams{}
+	watermark := Watermark{}
+	watermarkParams := false
+	text := TextOverlay{}
+	textParams := false
+
+	for _, p := range strings.Split(segment, ",") {
+		if p == "" {
+			continue
+		}
+
+		kv := strings.SplitN(p, ":", 2)
+
+		if len(kv) != 2 {
+			return params, invalidOption("params",
+				"Invalid transformation parameter '%s': expected name:value",
+				p)
+		}
+
+		name, value := kv[0], kv[1]
+
+		switch name {
+		case "fit":
+			fit, ok := fitModes[value]
+
+			if !ok {
+				return params, invalidOption("fit",
+					"Unsupported fit mode '%s': expected cover, contain, fill, inside or outside", value)
+			}
+
+			params.Fit = fit
+
+		case "gravity":
+			gravity, ok := gravities[value]
+
+			if !ok {
+				return params, invalidOption("gravity",
+					"Unsupported gravity '%s': expected center, north, northeast, east, southeast, south, southwest, west, northwest, smart, attention or entropy", value)
+			}
+
+			params.Gravity = gravity
+
+		case "dpr":
+			dpr, err := strconv.ParseFloat(value, 64)
+
+			if err != nil || dpr <= 0 {
+				return params, invalidOption("dpr",
+					"Invalid device pixel ratio '%s': expected > 0", value)
+			}
+
+			params.Dpr = dpr
+
+		case "bg":
+			if _, err := parseHexColor(value); err != nil {
+				return params, invalidOption("bg",
+					"Invalid background '%s': expected hex RGB or RGBA", value)
+			}
+
+			params.Background = strings.ToLower(strings.TrimPrefix(value, "#"))
+
+		case "rotate":
+			angle, err := strconv.Atoi(value)
+
+			if _, ok := rotations[angle]; err != nil || !ok {
+				return params, invalidOption("rotate",
+					"Unsupported rotation '%s': expected 0, 90, 180 or 270", value)
+			}
+
+			params.Rotate = angle
+
+		case "flip":
+			if value != "h" && value != "v" && value != "hv" {
+				return params, invalidOption("flip",
+					"Unsupported flip '%s': expected h, v or hv", value)
+			}
+
+			params.FlipH = strings.Contains(value, "h")
+			params.FlipV = strings.Contains(value, "v")
+
+		case "blur", "sharpen", "brightness", "contrast", "saturation":
+			v, err := strconv.ParseFloat(value, 64)
+
+			if err != nil {
+				return params, invalidOption(name,
+					"Invalid %s '%s': expected a number", name, value)
+			}
+
+			*params.Filters.value(name) = v
+
+		case "grayscale":
+			if value != "true" && value != "1" {
+				return params, invalidOption("grayscale",
+					"Invalid grayscale '%s': expected true", value)
+			}
+
+			params.Filters.Grayscale = true
+
+		case "quality":
+			quality, err := strconv.Atoi(value)
+
+			if err != nil || quality < 1 || quality > maxQuality {
+				return params, invalidOption("quality",
+					"Invalid quality '%s': expected 1 to %d", value, maxQuality)
+			}
+
+			params.Quality = quality
+
+		case "watermark":
+			if value == "" {
+				return params, invalidOption("watermark",
+					"Invalid watermark '%s': expected a name", value)
+			}
+
+			watermark.Name = value
+
+		case "wmpos":
+			watermark.Position = Gravity(value)
+			watermarkParams = true
+
+		case "wmmargin":
+			margin, err := strconv.Atoi(value)
+
+			if err != nil {
+				return params, invalidOption("wmmargin",
+					"Invalid watermark margin '%s': expected >= 0", value)
+			}
+
+			watermark.Margin = margin
+			watermark.MarginSet = true
+			watermarkParams = true
+
+		case "wmopacity", "wmsize":
+			v, err := strconv.ParseFloat(value, 64)
+
+			if err != nil {
+				return params, invalidOption(name,
+					"Invalid %s '%s': expected 0.0 to 1.0", name, value)
+			}
+
+			if name == "wmopacity" {
+				watermark.Opacity = v
+			} else {
+				watermark.Size = v
+				watermark.SizeSet = true
+			}
+
+			watermarkParams = true
+
+		case "text":
+			// URL-safe base64, as the text can contain any character
+			decoded, err := base64.RawURLEncoding.DecodeString(
+				strings.TrimRight(value, "="))
+
+			if err != nil || len(decoded) == 0 {
+				return params, invalidOption("text",
+					"Invalid text '%s': expected URL-safe base64", value)
+			}
+
+			text.Text = string(decoded)
+
+		case "font":
+			text.Font = value
+			textParams = true
+
+		case "textsize", "textwidth":
+			v, err := strconv.Atoi(value)
+
+			if err != nil {
+				return params, invalidOption(name,
+					"Invalid %s '%s': expected a number of pixels", name, value)
+			}
+
+			if name == "textsize" {
+				text.Size = v
+			} else {
+				text.Width = v
+			}
+
+			textParams = true
+
+		case "textcolor":
+			text.Color = strings.ToLower(strings.TrimPrefix(value, "#"))
+			textParams = true
+
+		case "textpos":
+			text.Position = Gravity(value)
+			textParams = true
+
+		default:
+			return params, invalidOption(name,
+				"Unsupported transformation parameter: %s", name)
+		}
+	}
+
+	if watermark.Name != "" {
+		if err := watermark.validate(); err != nil {
+			return params, err
+		}
+
+		params.Watermark = &watermark
+	} else if watermarkParams {
+		return params, invalidOption("watermark",
+			"Watermark parameters without watermark: %s", segment)
+	}
+
+	if text.Text != "" {
+		if err := text.validate(); err != nil {
+			return params, err
+		}
+
+		params.Text = &text
+	} else if textParams {
+		return params, invalidOption("text",
+			"Text parameters without text: %s", segment)
+	}
+
+	return params, params.Filters.validate()
+}
+
+// Returns the normalized representation of the parameters
+// (empty if none).
+func (p transformParams) String() string {
+	repr := []string{}
+
+	if p.Fit != "" && p.Fit != FitInside {
+		repr = append(repr, "fit:"+string(p.Fit))
+	}
+
+	if p.Gravity != "" {
+		repr = append(repr, "gravity:"+string(p.Gravity))
+	}
+
+	if p.Dpr > 0 && p.Dpr != 1 {
+		repr = append(repr,
+			"dpr:"+strconv.FormatFloat(p.Dpr, 'f', -1, 64))
+	}
+
+	if p.Background != "" {
+		repr = append(repr, "bg:"+p.Background)
+	}
+
+	if p.Rotate != 0 {
+		repr = append(repr, "rotate:"+strconv.Itoa(p.Rotate))
+	}
+
+	if p.FlipH && p.FlipV {
+		repr = append(repr, "flip:hv")
+	} else if p.FlipH {
+		repr = append(repr, "flip:h")
+	} else if p.FlipV {
+		repr = append(repr, "flip:v")
+	}
+
+	for _, name := range filterNames {
+		if v := *p.Filters.value(name); v != 0 {
+			repr = append(repr,
+				name+":"+strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+
+	if p.Filters.Grayscale {
+		repr = append(repr, "grayscale:true")
+	}
+
+	if p.Quality != 0 {
+		repr = append(repr, "quality:"+strconv.Itoa(p.Quality))
+	}
+
+	if w := p.Watermark; w != nil {
+		repr = append(repr, "watermark:"+w.Name)
+
+		if w.Position != "" {
+			repr = append(repr, "wmpos:"+string(w.Position))
+		}
+
+		if w.Margin != 0 || w.MarginSet {
+			repr = append(repr, "wmmargin:"+strconv.Itoa(w.Margin))
+		}
+
+		if w.Opacity != 0 {
+			repr = append(repr,
+				"wmopacity:"+strconv.FormatFloat(w.Opacity, 'f', -1, 64))
+		}
+
+		if w.Size != 0 || w.SizeSet {
+			repr = append(repr,
+				"wmsize:"+strconv.FormatFloat(w.Size, 'f', -1, 64))
+		}
+	}
+
+	if t := p.Text; t != nil {
+		repr = append(repr,
+			"text:"+base64.RawURLEncoding.EncodeToString([]byte(t.Text)))
+
+		if t.Font != "" {
+			repr = append(repr, "font:"+t.Font)
+		}
+
+		if t.Size != 0 {
+			repr = append(repr, "textsize:"+strconv.Itoa(t.Size))
+		}
+
+		if t.Color != "" {
+			repr = append(repr, "textcolor:"+t.Color)
+		}
+
+		if t.Position != "" {
+			repr = append(repr, "textpos:"+string(t.Position))
+		}
+
+		if t.Width != 0 {
+			repr = append(repr, "textwidth:"+strconv.Itoa(t.Width))
+		}
+	}
+
+	sort.Strings(repr)
+
+	return strings.Join(repr, ",")
+}
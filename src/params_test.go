@@ -0,0 +1,182 @@
+package nuggan
+
+import (
+	"testing"
+)
+
+func TestParseParams(t *testing.T) {
+	params, err := parseParams("fit:cover")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if params.Fit != FitCover {
+		t.Errorf("Unexpected fit: %s", params.Fit)
+	}
+
+	if repr := params.String(); repr != "fit:cover" {
+		t.Errorf("Unexpected representation: %s", repr)
+	}
+}
+
+func TestDefaultParams(t *testing.T) {
+	params, err := parseParams("fit:inside")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if repr := params.String(); repr != "" {
+		t.Errorf("Default parameters must be omitted: %s", repr)
+	}
+}
+
+func TestInvalidParams(t *testing.T) {
+	for segment, expected := range map[string]string{
+		"fit":        "Invalid transformation parameter 'fit': expected name:value",
+		"fit:crop":   "Unsupported fit mode 'crop': expected cover, contain, fill, inside or outside",
+		"zoom:2":     "Unsupported transformation parameter: zoom",
+		"fit:cover,": "",
+	} {
+		_, err := parseParams(segment)
+
+		if expected == "" {
+			if err != nil {
+				t.Errorf("%s: %v", segment, err)
+			}
+		} else if err == nil || err.Error() != expected {
+			t.Errorf("Expected error '%s': %v", expected, err)
+		}
+	}
+}
+
+func TestParseGravity(t *testing.T) {
+	params, err := parseParams("gravity:smart,fit:cover")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if params.Gravity != GravityAttention {
+		t.Errorf("Unexpected gravity: %s", params.Gravity)
+	}
+
+	if repr := params.String(); repr != "fit:cover,gravity:attention" {
+		t.Errorf("Unexpected representation: %s", repr)
+	}
+
+	if _, err := parseParams("gravity:top"); err == nil {
+		t.Error("Unsupported gravity must be refused")
+	}
+}
+
+func TestParseDpr(t *testing.T) {
+	params, err := parseParams("dpr:2")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if params.Dpr != 2 || params.String() != "dpr:2" {
+		t.Errorf("Unexpected DPR: %f (%s)", params.Dpr, params)
+	}
+
+	for _, v := range []string{"dpr:0", "dpr:-1", "dpr:x"} {
+		if _, err := parseParams(v); err == nil {
+			t.Errorf("Invalid DPR must be refused: %s", v)
+		}
+	}
+}
+
+func TestParseQuality(t *testing.T) {
+	params, err := parseParams("quality:70")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if params.Quality != 70 || params.String() != "quality:70" {
+		t.Errorf("Unexpected quality: %d (%s)", params.Quality, params)
+	}
+
+	for _, v := range []string{"quality:0", "quality:101", "quality:x"} {
+		if _, err := parseParams(v); err == nil {
+			t.Errorf("Invalid quality must be refused: %s", v)
+		}
+	}
+}
+
+func TestParseBackground(t *testing.T) {
+	params, err := parseParams("bg:FFFFFF80")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if params.Background != "ffffff80" || params.String() != "bg:ffffff80" {
+		t.Errorf("Unexpected background: %s (%s)", params.Background, params)
+	}
+
+	for _, v := range []string{"bg:", "bg:fff", "bg:white"} {
+		if _, err := parseParams(v); err == nil {
+			t.Errorf("Invalid background must be refused: %s", v)
+		}
+	}
+}
+
+func TestParseOrientation(t *testing.T) {
+	params, err := parseParams("rotate:90,flip:hv")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if params.Rotate != 90 || !params.FlipH || !params.FlipV {
+		t.Errorf("Unexpected orientation: %v", params)
+	}
+
+	if repr := params.String(); repr != "flip:hv,rotate:90" {
+		t.Errorf("Unexpected representation: %s", repr)
+	}
+
+	for _, segment := range []string{"rotate:45", "rotate:x", "flip:x"} {
+		if _, err := parseParams(segment); err == nil {
+			t.Errorf("Invalid orientation must be refused: %s", segment)
+		}
+	}
+}
+
+func TestParseFilters(t *testing.T) {
+	params, err := parseParams("blur:5,sharpen:0.5,grayscale:1,brightness:-10,contrast:20,saturation:50")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := Filters{
+		Blur:       5,
+		Sharpen:    0.5,
+		Grayscale:  true,
+		Brightness: -10,
+		Contrast:   20,
+		Saturation: 50,
+	}
+
+	if params.Filters != expected {
+		t.Errorf("%v != %v", params.Filters, expected)
+	}
+
+	if repr := params.String(); repr != "blur:5,brightness:-10,contrast:20,grayscale:true,saturation:50,sharpen:0.5" {
+		t.Errorf("Unexpected representation: %s", repr)
+	}
+
+	for _, segment := range []string{
+		"blur:x", "blur:-1", "blur:101", "sharpen:11",
+		"brightness:101", "contrast:-101", "grayscale:no",
+	} {
+		if _, err := parseParams(segment); err == nil {
+			t.Errorf("Invalid filter must be refused: %s", segment)
+		}
+	}
+}
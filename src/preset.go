@@ -0,0 +1,107 @@
+package nuggan
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Named transformation, requested as `/:routePrefix/p/:preset/:base64Ref`
+type PresetConfig struct {
+	Crop        string // ':cropX/:cropY/:cropWidth/:cropHeight', defaulted to '0/0/-/-'
+	Resize      string // ':resizeWidth/:resizeHeight', defaulted to '-/-'
+	Compression string // compression level, defaulted to '-'
+	Params      string // transformation parameters (e.g. 'fit:cover')
+	Format      string // output format, unless specified with the reference
+}
+
+// Checks whether the (unsigned) path is a preset route.
+func isPresetRoute(path []string) bool {
+	return len(path) == 5 && path[2] == "p"
+}
+
+// Returns the segments of the transformation path
+// (from `:cropX` to the optional `:params`).
+func (p PresetConfig) segments() []string {
+	crop := p.Crop
+
+	if crop == "" {
+		crop = "0/0/-/-"
+	}
+
+	resize := p.Resize
+
+	if resize == "" {
+		resize = "-/-"
+	}
+
+	compression := p.Compression
+
+	if compression == "" {
+		compression = "-"
+	}
+
+	segments := strings.Split(crop+"/"+resize+"/"+compression, "/")
+
+	if p.Params != "" {
+		segments = append(segments, p.Params)
+	}
+
+	return segments
+}
+
+// Expands the preset route (`/:routePrefix/p/:preset/:base64Ref`)
+// as the path of the corresponding transformation.
+func expandPreset(path []string, preset PresetConfig) []string {
+	ref := path[4]
+
+	if preset.Format != "" && !strings.Contains(ref, ".") {
+		ref = ref + "." + preset.Format
+	}
+
+	expanded := append([]string{path[0], path[1]}, preset.segments()...)
+
+	return append(expanded, ref)
+}
+
+// Checks the preset settings,
+// including its transformation (against the `limits`).
+func validatePreset(
+	name string, preset PresetConfig, limits LimitsConfig) error {
+
+	if name == "" || strings.Contains(name, "/") {
+		return errors.New(fmt.Sprintf("Invalid preset name: '%s'", name))
+	}
+
+	if len(strings.Split(preset.Crop, "/")) != 4 && preset.Crop != "" {
+		return errors.New(fmt.Sprintf(
+			"Invalid crop for preset '%s': %s", name, preset.Crop))
+	}
+
+	if len(strings.Split(preset.Resize, "/")) != 2 && preset.Resize != "" {
+		return errors.New(fmt.Sprintf(
+			"Invalid resize for preset '%s': %s", name, preset.Resize))
+	}
+
+	opts, err := ParseTransformation(preset.segments())
+
+	if err == nil {
+		opts.Limits = limits
+		err = opts.Validate()
+	}
+
+	if err != nil {
+		return errors.New(fmt.Sprintf(
+			"Invalid transformation for preset '%s': %s", name, err))
+	}
+
+	if preset.Format != "" {
+		if _, ok := outputFormats[strings.ToLower(preset.Format)]; !ok {
+			return errors.New(fmt.Sprintf(
+				"Unsupported format for preset '%s': %s",
+				name, preset.Format))
+		}
+	}
+
+	return nil
+}
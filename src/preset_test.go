@@ -0,0 +1,57 @@
+package nuggan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandPreset(t *testing.T) {
+	path := strings.Split("/optimg/p/thumbnail/_2_L3BvcHRvY2F0X3YyLnBuZw==", "/")
+
+	if !isPresetRoute(path) {
+		t.Fatal("Preset route expected")
+	}
+
+	preset := PresetConfig{
+		Resize:      "320/-",
+		Compression: "7",
+		Params:      "fit:cover",
+		Format:      "webp",
+	}
+
+	expected := strings.Split("/optimg/0/0/-/-/320/-/7/fit:cover/_2_L3BvcHRvY2F0X3YyLnBuZw==.webp", "/")
+
+	if got := expandPreset(path, preset); !reflect.DeepEqual(got, expected) {
+		t.Errorf("%v != %v", got, expected)
+	}
+}
+
+func TestExpandPresetWithFormat(t *testing.T) {
+	path := strings.Split("/optimg/p/original/_2_L3BvcHRvY2F0X3YyLnBuZw==.png", "/")
+
+	expected := strings.Split("/optimg/0/0/-/-/-/-/-/_2_L3BvcHRvY2F0X3YyLnBuZw==.png", "/")
+
+	got := expandPreset(path, PresetConfig{Format: "webp"})
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("%v != %v", got, expected)
+	}
+}
+
+func TestInvalidPreset(t *testing.T) {
+	for _, preset := range []PresetConfig{
+		{Crop: "0/0"},
+		{Resize: "320"},
+		{Crop: "a/0/-/-"},
+		{Resize: "-1/-"},
+		{Compression: "x"},
+		{Params: "fit:crop"},
+		{Params: "blur:1000"},
+		{Format: "bmp"},
+	} {
+		if err := validatePreset("thumbnail", preset, LimitsConfig{}); err == nil {
+			t.Errorf("Invalid preset must be refused: %v", preset)
+		}
+	}
+}
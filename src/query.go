@@ -0,0 +1,119 @@
+package nuggan
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Query parameters of the `img` route, corresponding to the segments
+// of the transformation path (from `:cropX` to `:compressionLevel`)
+var querySegments = []struct {
+	name string
+	none string
+}{
+	{"x", "0"},
+	{"y", "0"},
+	{"cw", "-"},
+	{"ch", "-"},
+	{"w", "-"},
+	{"h", "-"},
+	{"compression", "-"},
+}
+
+// Query parameters corresponding to the transformation parameters
+var queryParams = []string{
+	"fit", "gravity", "dpr", "bg", "rotate", "flip",
+	"blur", "sharpen", "grayscale", "brightness", "contrast", "saturation",
+	"watermark", "wmpos", "wmmargin", "wmopacity", "wmsize",
+	"text", "font", "textsize", "textcolor", "textpos", "textwidth"}
+
+// Checks whether the (unsigned) path is a query route.
+func isQueryRoute(path []string) bool {
+	return len(path) == 4 && path[2] == "img"
+}
+
+// Expands the query route
+// (`/:routePrefix/img/:base64Ref?w=320&h=200&fit=cover&fmt=webp&q=70`)
+// as the path of the corresponding transformation.
+func expandQuery(path []string, query url.Values) ([]string, error) {
+	expanded := []string{path[0], path[1]}
+
+	for _, s := range querySegments {
+		v, err := queryValue(query, s.name, "/")
+
+		if err != nil {
+			return nil, err
+		}
+
+		if v == "" {
+			v = s.none
+		}
+
+		if s.name == "w" && v != "-" {
+			if up, _ := queryValue(query, "up", ""); up == "1" || up == "true" {
+				v = v + "+"
+			}
+		}
+
+		expanded = append(expanded, v)
+	}
+
+	params := []string{}
+
+	for _, name := range queryParams {
+		v, err := queryValue(query, name, "/,")
+
+		if err != nil {
+			return nil, err
+		}
+
+		if v != "" {
+			params = append(params, name+":"+v)
+		}
+	}
+
+	// `q` being the usual name of the quality
+	q, err := queryValue(query, "q", "/,")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if q != "" {
+		params = append(params, "quality:"+q)
+	}
+
+	if len(params) > 0 {
+		expanded = append(expanded, strings.Join(params, ","))
+	}
+
+	// ---
+
+	ref := path[3]
+	format, err := queryValue(query, "fmt", "/.")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if format != "" && !strings.Contains(ref, ".") {
+		ref = ref + "." + format
+	}
+
+	return append(expanded, ref), nil
+}
+
+// Returns the value of the query parameter (empty if none),
+// checking it doesn't contain any of the `reserved` characters.
+func queryValue(query url.Values, name string, reserved string) (string, error) {
+	v := query.Get(name)
+
+	if reserved != "" && strings.ContainsAny(v, reserved) {
+		return "", errors.New(fmt.Sprintf(
+			"Invalid query parameter '%s': %s", name, v))
+	}
+
+	return v, nil
+}
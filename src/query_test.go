@@ -0,0 +1,120 @@
+package nuggan
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandQuery(t *testing.T) {
+	path := strings.Split("/optimg/img/_2_L3BvcHRvY2F0X3YyLnBuZw==", "/")
+
+	if !isQueryRoute(path) {
+		t.Fatal("Query route expected")
+	}
+
+	query, _ := url.ParseQuery("w=320&h=200&fit=cover&fmt=webp&q=70&compression=7")
+
+	expected := strings.Split("/optimg/0/0/-/-/320/200/7/fit:cover,quality:70/_2_L3BvcHRvY2F0X3YyLnBuZw==.webp", "/")
+
+	got, err := expandQuery(path, query)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("%v != %v", got, expected)
+	}
+}
+
+func TestExpandQueryUpscale(t *testing.T) {
+	path := strings.Split("/optimg/img/_2_L3BvcHRvY2F0X3YyLnBuZw==.png", "/")
+	query, _ := url.ParseQuery("x=10&y=-0.5&cw=50p&w=320&up=1&dpr=2")
+
+	expected := strings.Split("/optimg/10/-0.5/50p/-/320+/-/-/dpr:2/_2_L3BvcHRvY2F0X3YyLnBuZw==.png", "/")
+
+	got, err := expandQuery(path, query)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("%v != %v", got, expected)
+	}
+}
+
+func TestExpandQueryWithoutParameter(t *testing.T) {
+	path := strings.Split("/optimg/img/_2_L3BvcHRvY2F0X3YyLnBuZw==", "/")
+
+	expected := strings.Split("/optimg/0/0/-/-/-/-/-/_2_L3BvcHRvY2F0X3YyLnBuZw==", "/")
+
+	got, err := expandQuery(path, url.Values{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("%v != %v", got, expected)
+	}
+}
+
+func TestInvalidQuery(t *testing.T) {
+	path := strings.Split("/optimg/img/_2_L3BvcHRvY2F0X3YyLnBuZw==", "/")
+
+	for _, repr := range []string{
+		"w=320/200",
+		"fit=cover,gravity:north",
+		"fmt=png/x",
+	} {
+		query, _ := url.ParseQuery(repr)
+
+		if _, err := expandQuery(path, query); err == nil {
+			t.Errorf("Invalid query must be refused: %s", repr)
+		}
+	}
+}
+
+func TestQueryQuality(t *testing.T) {
+	origin := httptest.NewServer(http.FileServer(http.Dir("../test")))
+
+	defer origin.Close()
+
+	conf, err := LoadConfig(strings.NewReader(`
+groupedBaseUrls = [ [ "` + origin.URL + `" ] ]
+`))
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	serve := Service(conf)
+	ref := EncodeMediaUrl(conf)(origin.URL + "/image1.jpg")
+
+	for q, expected := range map[string]int{"70": 200, "0": 400, "101": 400} {
+		status := 200
+
+		serve(&ImageRequest{
+			Path: "/optimg/img/" + ref,
+			Query: url.Values{
+				"w": []string{"320"}, "fmt": []string{"webp"},
+				"q": []string{q}},
+			Method: "GET",
+			Header: http.Header{},
+		}, &ImageResponse{
+			SetStatusCode: func(code int) { status = code },
+			SetHeader:     func(string, string) {},
+			Body:          new(bytes.Buffer),
+		})
+
+		if status != expected {
+			t.Errorf("Status %d expected for quality %s: %d", expected, q, status)
+		}
+	}
+}
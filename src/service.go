@@ -1,14 +1,18 @@
 package nuggan
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/davidbyttow/govips/pkg/vips"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type ImageReferer struct {
@@ -18,8 +22,10 @@ type ImageReferer struct {
 
 type ImageRequest struct {
 	Path    string
+	Query   url.Values
 	Method  string
 	Referer ImageReferer
+	Header  http.Header
 }
 
 type ImageResponse struct {
@@ -33,164 +39,287 @@ type ImageResponse struct {
 //	HEAD /:routePrefix/:cropX/:cropY/:cropWidth/:cropHeight/:resizeWidth/:resizeHeight/:compressionLevel/:base64Ref
 //
 //	GET  /:routePrefix/:cropX/:cropY/:cropWidth/:cropHeight/:resizeWidth/:resizeHeight/:compressionLevel/:base64Ref
+//
+// The `:base64Ref` can be suffixed with an output format (e.g. `.webp`),
+// and be preceded by optional `:params` (e.g. `fit:cover`):
+//
+//	GET  /:routePrefix/:cropX/:cropY/:cropWidth/:cropHeight/:resizeWidth/:resizeHeight/:compressionLevel/:params/:base64Ref
+//
+// When secrets are configured, the routes are signed
+// (with `:signature` optionally prefixed as `:expires.:signature`):
+//
+//	GET  /:routePrefix/:signature/:cropX/:cropY/:cropWidth/:cropHeight/:resizeWidth/:resizeHeight/:compressionLevel/:base64Ref
+//
+// The transformations configured as presets can be requested by name:
+//
+//	GET  /:routePrefix/p/:preset/:base64Ref
+//
+// The transformation can also be specified as query parameters:
+//
+//	GET  /:routePrefix/img/:base64Ref?w=320&h=200&fit=cover&fmt=webp&q=7
 func Service(conf Config) func(*ImageRequest, *ImageResponse) {
-	decodeMediaUrl := DecodeMediaUrl(conf)
+	decodeMediaUrls := DecodeMediaUrls(conf)
+	mirrors := newMirrorSelector(conf.BaseUrlSelection)
+	verifyPath := VerifyPath(conf)
+	signed := len(conf.Secrets) > 0
+
+	originConf := limitedOrigin(conf.Origin, conf.Limits)
+	origin := newOriginFetcher(originConf, nil)
+	guardedOrigin := newOriginFetcher(
+		originConf, newOriginGuard(originConf))
+	watermarks := newWatermarkStore(conf, origin, mirrors)
+
+	// If the fonts directory can't be used, the text overlays are refused
+	// rather than rendered with the system fonts
+	var fontsErr error = nil
+
+	if conf.FontsDir != "" {
+		if err := UseFontsDir(conf.FontsDir); err != nil {
+			fontsErr = errors.New(fmt.Sprintf(
+				"Text overlays unavailable, as fonts directory cannot be used: %s", err))
+
+			log.Printf("ERROR: %s\n", fontsErr)
+		}
+	}
+
+	cache := serviceCache(conf)
+	encodings := &flightGroup{}
+
+	acceptFormats := make([]vips.ImageType, len(conf.AcceptFormats))
+
+	for i, f := range conf.AcceptFormats {
+		acceptFormats[i] = outputFormats[strings.ToLower(f)]
+	}
 
 	return func(req *ImageRequest, resp *ImageResponse) {
 		path := strings.Split(req.Path, "/")
-		fsz := len(path)
 
-		if fsz < 9 {
-			badRequest(resp, fmt.Sprintf(
-				"Unexpected request to '%s'", req.Path))
-			return
-		} else {
-			log.Printf("INFO: Serving /%s: %s\n", path[1], path[2:])
+		if signed {
+			presetRoute := len(path) == 6 && path[3] == "p"
+			queryRoute := len(path) == 5 && path[3] == "img"
 
-			base64Ref := path[9]
+			if len(path) < 11 && !presetRoute && !queryRoute {
+				forbidden(resp, fmt.Sprintf(
+					"Unsigned request to '%s'", req.Path))
+				return
+			}
 
-			if !strings.HasPrefix(base64Ref, "_") && conf.Strict {
-				msg := fmt.Sprintf("Base64url '%s' cannot be specified in strict mode", base64Ref)
+			signature, expires, err := parseSignature(path[2])
 
-				forbidden(resp, msg)
+			if err != nil {
+				forbidden(resp, err.Error())
 				return
 			}
 
-			// ---
+			signedPath := strings.Join(path[3:], "/")
 
-			// crop x offset (mandatory)
-			x, err := strconv.Atoi(path[2])
+			if queryRoute && len(req.Query) > 0 {
+				// Normalized query (sorted by name)
+				signedPath = signedPath + "?" + req.Query.Encode()
+			}
 
-			if err != nil {
-				msg := fmt.Sprintf(
-					"Invalid crop x offset: %s",
-					err.Error())
+			if expires != -1 {
+				signedPath = expiringPath(expires, signedPath)
+			}
 
-				badRequest(resp, msg)
+			if !verifyPath(signature, signedPath) {
+				forbidden(resp, fmt.Sprintf(
+					"Invalid signature for '%s'", req.Path))
 				return
 			}
 
-			// crop y offset (mandatory)
-			y, err := strconv.Atoi(path[3])
-
-			if err != nil {
-				msg := fmt.Sprintf(
-					"Invalid crop y offset: %s",
-					err.Error())
-
-				badRequest(resp, msg)
+			if expires != -1 && time.Now().Unix() > expires {
+				gone(resp, fmt.Sprintf(
+					"Signature expired at %s for '%s'",
+					time.Unix(expires, 0).UTC().Format(time.RFC3339),
+					req.Path))
 				return
 			}
 
-			// crop width
-			cropW := -1
+			// Unsigned path
+			path = append(path[:2], path[3:]...)
+		}
 
-			if path[4] != "-" {
-				wp, err := strconv.Atoi(path[4])
+		if isPresetRoute(path) {
+			preset, ok := conf.Presets[path[3]]
 
-				if err != nil {
-					msg := fmt.Sprintf(
-						"Invalid crop width: %s",
-						err.Error())
+			if !ok {
+				badRequest(resp, fmt.Sprintf(
+					"Unknown preset '%s'", path[3]))
+				return
+			}
 
-					badRequest(resp, msg)
-					return
-				}
+			path = expandPreset(path, preset)
+		} else if conf.PresetsOnly {
+			forbidden(resp, fmt.Sprintf(
+				"Only presets can be requested: '%s'", req.Path))
+			return
+		} else if isQueryRoute(path) {
+			expanded, err := expandQuery(path, req.Query)
 
-				cropW = wp
+			if err != nil {
+				badRequest(resp, err.Error())
+				return
 			}
 
-			// crop height
-			cropH := -1
+			path = expanded
+		}
 
-			if path[5] != "-" {
-				h, err := strconv.Atoi(path[5])
+		fsz := len(path)
 
-				if err != nil {
-					msg := fmt.Sprintf(
-						"Invalid crop height: %s",
-						err.Error())
+		if fsz < 10 {
+			badRequest(resp, fmt.Sprintf(
+				"Unexpected request to '%s'", req.Path))
+			return
+		} else {
+			log.Printf("INFO: Serving /%s: %s\n", path[1], path[2:])
 
-					badRequest(resp, msg)
-					return
-				}
+			// optional parameters, before the media reference
+			refIndex := 9
 
-				cropH = h
+			if fsz > 10 {
+				refIndex = 10
 			}
 
-			// resize width
-			resizeW := -1
+			base64Ref, outFmt, err := parseOutputFormat(path[refIndex])
 
-			if path[6] != "-" {
-				vw, err := strconv.Atoi(path[6])
+			if err != nil {
+				badRequest(resp, err.Error())
+				return
+			}
 
-				if err != nil {
-					msg := fmt.Sprintf(
-						"Invalid resize width '%s': %v",
-						path[6], err.Error())
+			negotiated := false
 
-					badRequest(resp, msg)
-					return
-				}
+			if outFmt == vips.ImageTypeUnknown && len(acceptFormats) > 0 {
+				negotiated = true
 
-				resizeW = vw
+				outFmt = negotiateFormat(
+					acceptFormats, req.Header.Get("Accept"))
 			}
 
-			// resize height
-			resizeH := -1
+			if !strings.HasPrefix(base64Ref, "_") && conf.Strict {
+				msg := fmt.Sprintf("Base64url '%s' cannot be specified in strict mode", base64Ref)
 
-			if path[7] != "-" {
-				vh, err := strconv.Atoi(path[7])
+				forbidden(resp, msg)
+				return
+			}
 
-				if err != nil {
-					msg := fmt.Sprintf(
-						"Invalid resize height '%s': %v", path[7], err.Error())
+			// ---
 
-					badRequest(resp, msg)
-					return
-				}
+			opts, err := ParseTransformation(path[2:refIndex])
 
-				resizeH = vh
+			if err != nil {
+				transformError(resp, err)
+				return
 			}
 
-			// compression level
-			compressionLevel := -1
+			opts.Format = outFmt
+			opts.Limits = conf.Limits
 
-			if path[8] != "-" {
-				cl, err := strconv.Atoi(path[8])
+			if err := opts.Validate(); err != nil {
+				transformError(resp, err)
+				return
+			}
 
-				if err != nil {
-					msg := fmt.Sprintf(
-						"Invalid compression level '%s': %v", path[8], err.Error())
+			if opts.Text != nil && fontsErr != nil {
+				writeError(resp, fontsErr)
+				return
+			}
 
-					badRequest(resp, msg)
+			params := opts.params()
+
+			if opts.Watermark != nil {
+				// Copy, with the image & configured settings
+				watermark := *opts.Watermark
+
+				if err := watermarks.Resolve(&watermark); err != nil {
+					transformError(resp, err)
 					return
 				}
 
-				compressionLevel = cl
+				opts.Watermark = &watermark
 			}
 
 			// media
-			mediaUrl, err := decodeMediaUrl(base64Ref)
+			mediaGroup, mediaUrls, err := decodeMediaUrls(base64Ref)
 
 			if err != nil {
 				writeError(resp, err)
 				return
 			}
 
+			mediaUrl := mediaUrls[0]
+
 			log.Printf("INFO: Resolve backend URL: '%s'\n",
 				mediaUrl)
 
-			// Fetch image from public HTTP URL
-			imgResp, err := http.Get(mediaUrl)
+			etagPath := strings.Join(path[1:9], "/")
+
+			if p := params.String(); p != "" {
+				etagPath = etagPath + "/" + p
+			}
+
+			if outFmt != vips.ImageTypeUnknown {
+				etagPath = etagPath + "/" + vips.ImageTypes[outFmt]
+			}
+
+			// Normalized transformation & resolved media
+			transformKey := fmt.Sprintf("%s/%s/%s/%s/%d/%d/%t/%d/%s/%s %s",
+				opts.X, opts.Y, opts.cropWidth(), opts.cropHeight(),
+				opts.resizeWidth(), opts.resizeHeight(), opts.Upscale,
+				opts.compression(), params,
+				vips.ImageTypes[outFmt], mediaUrl)
+
+			var cached *cachedImage = nil
+
+			if cache != nil {
+				if c, ok := cache.Get(transformKey); ok {
+					serveCached(req, resp, c, conf.CacheControl)
+					return
+				}
+
+				resp.SetHeader("X-Cache", "MISS")
+
+				cached = &cachedImage{
+					Header: make(map[string]string),
+				}
+
+				resp = recordHeaders(resp, cached.Header)
+			}
+
+			if negotiated {
+				// Recorded with the cached image (if any)
+				resp.SetHeader("Vary", "Accept")
+			}
+
+			// Fetch image from public HTTP URL,
+			// possibly forwarding conditions
+			cond := originConditions(req.Header, etagPath, base64Ref)
+
+			fetcher := origin
+
+			if !strings.HasPrefix(base64Ref, "_") {
+				// Not from a configured group
+				fetcher = guardedOrigin
+			}
+
+			imgResp, err := fetcher.Fetch(mirrors.Order(mediaGroup, mediaUrls), cond)
 
 			if err != nil {
-				writeError(resp, err)
+				var denied *forbiddenOriginError
+				var tooLarge *originTooLargeError
+
+				if errors.As(err, &denied) {
+					forbidden(resp, denied.Error())
+				} else if errors.As(err, &tooLarge) {
+					entityTooLarge(resp, tooLarge.Error())
+				} else {
+					writeError(resp, err)
+				}
+
 				return
 			}
 
-			defer imgResp.Body.Close()
-
 			status := imgResp.StatusCode
 
 			if status == 404 {
@@ -201,13 +330,15 @@ func Service(conf Config) func(*ImageRequest, *ImageResponse) {
 					req.Referer,
 					resp,
 					errors.New(msg),
-					resizeW,
-					resizeH)
+					opts.resizeWidth(),
+					opts.resizeHeight())
 
 				return
 			}
 
-			if status != 200 {
+			notModified := (status == 304 && len(cond) > 0)
+
+			if status != 200 && !notModified {
 				msg := fmt.Sprintf(
 					"Fails to fetch media '%s': %d",
 					base64Ref, status)
@@ -216,14 +347,15 @@ func Service(conf Config) func(*ImageRequest, *ImageResponse) {
 					req.Referer,
 					resp,
 					errors.New(msg),
-					resizeW,
-					resizeH)
+					opts.resizeWidth(),
+					opts.resizeHeight())
 
 				return
 			}
 
 			// Prepare headers
 			origEtag := base64Ref
+			hasEtag := false
 
 			for name, vs := range imgResp.Header {
 				for _, v := range vs {
@@ -231,6 +363,8 @@ func Service(conf Config) func(*ImageRequest, *ImageResponse) {
 						if v[0] == '"' { // unquote
 							origEtag =
 								v[1 : len(v)-1]
+
+							hasEtag = true
 						}
 					}
 
@@ -241,16 +375,20 @@ func Service(conf Config) func(*ImageRequest, *ImageResponse) {
 				}
 			}
 
-			etag := path[:9]
-			etag[0] = origEtag
-
-			resp.SetHeader("Etag", strings.Join(etag, "/"))
+			if hasEtag || !notModified {
+				resp.SetHeader("Etag", origEtag+"/"+etagPath)
+			}
 
 			if conf.CacheControl != "" {
 				resp.SetHeader(
 					"Cache-Control", conf.CacheControl)
 			}
 
+			if notModified {
+				resp.SetStatusCode(304)
+				return
+			}
+
 			// ---
 
 			if req.Method == "HEAD" {
@@ -259,51 +397,156 @@ func Service(conf Config) func(*ImageRequest, *ImageResponse) {
 
 			// ---
 
-			// Apply crop
-			croppedImg, err := Crop(
-				imgResp.Body, x, y, cropW, cropH)
+			// Crop, resize & encode (once for concurrent requests)
+			v, err := encodings.Do(
+				transformKey+" "+origEtag,
+				func() (interface{}, error) {
+					return encodeImage(
+						bytes.NewReader(imgResp.Body), opts)
+				})
 
 			if err != nil {
-				writeError(resp, err)
+				transformError(resp, err)
 				return
 			}
 
-			defer croppedImg.Close()
+			encoded, ok := v.(*encodedImage)
 
-			imgFmt := croppedImg.Format()
+			if !ok {
+				writeError(resp, errors.New("No encoded image"))
+				return
+			}
 
 			resp.SetHeader(
 				"Content-Type",
 				fmt.Sprintf(
-					"image/%s", vips.ImageTypes[imgFmt]))
+					"image/%s", vips.ImageTypes[encoded.Format]))
 
 			resp.SetHeader(
 				"Content-Disposition",
 				fmt.Sprintf("inline; filename=\"%s%s\"",
-					base64Ref, imgFmt.OutputExt()))
+					base64Ref, encoded.Format.OutputExt()))
 
-			// Output image on response
-			var rerr error = nil
+			if params.Dpr > 0 {
+				resp.SetHeader("Content-DPR",
+					strconv.FormatFloat(encoded.Dpr, 'f', -1, 64))
+			}
 
-			if resizeW > 0 {
-				rerr = ScaleDown(
-					croppedImg,
-					resizeW,
-					resizeH,
-					compressionLevel,
-					resp.Body)
+			if cached != nil {
+				cached.Body = encoded.Body
 
-			} else {
-				rerr = Strip(
-					croppedImg, resp.Body, compressionLevel)
+				cache.Set(transformKey, cached)
 			}
 
-			if rerr != nil {
-				writeError(resp, rerr)
-				return
-			}
+			resp.Body.Write(encoded.Body)
+		}
+	}
+}
+
+type encodedImage struct {
+	Body   []byte
+	Format vips.ImageType
+	Dpr    float64 // effective device pixel ratio
+}
+
+// Transforms the input image according the options (see `Transform`).
+func encodeImage(
+	input io.Reader,
+	opts TransformOptions) (*encodedImage, error) {
+
+	output := new(bytes.Buffer)
+
+	res, err := Transform(context.Background(), input, opts, output)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &encodedImage{
+		Body:   output.Bytes(),
+		Format: res.Format,
+		Dpr:    res.Dpr,
+	}, nil
+}
+
+// Returns the cache of transformed images according the configuration
+// (or nil if none).
+func serviceCache(conf Config) imageCache {
+	levels := tieredCache{}
+
+	if conf.MemoryCacheSize > 0 {
+		levels = append(levels,
+			newMemoryCache(conf.MemoryCacheSize, conf.MemoryCacheTtl))
+	}
+
+	if conf.DiskCacheDir != "" {
+		disk, err := newDiskCache(
+			conf.DiskCacheDir, conf.DiskCacheSize, conf.DiskCacheTtl)
+
+		if err != nil {
+			log.Printf("ERROR: Disk cache disabled: %s\n", err)
+		} else {
+			levels = append(levels, disk)
 		}
 	}
+
+	if len(levels) == 0 {
+		return nil
+	} else if len(levels) == 1 {
+		return levels[0]
+	}
+
+	return levels
+}
+
+// Serves an image from the cache.
+func serveCached(
+	req *ImageRequest,
+	resp *ImageResponse,
+	cached *cachedImage,
+	cacheControl string) {
+
+	resp.SetHeader("X-Cache", "HIT")
+
+	for name, v := range cached.Header {
+		resp.SetHeader(name, v)
+	}
+
+	if cacheControl != "" {
+		resp.SetHeader("Cache-Control", cacheControl)
+	}
+
+	if notModified(req.Header, cached.Header) {
+		resp.SetStatusCode(304)
+		return
+	}
+
+	if req.Method == "HEAD" {
+		return
+	}
+
+	resp.Body.Write(cached.Body)
+}
+
+// Returns a response that records the headers to be cached
+// in the given `header` map, in addition to setting them.
+func recordHeaders(
+	resp *ImageResponse,
+	header map[string]string) *ImageResponse {
+
+	return &ImageResponse{
+		SetStatusCode: resp.SetStatusCode,
+		SetHeader: func(k string, v string) {
+			for _, name := range cachedHeaders {
+				if k == name {
+					header[k] = v
+				}
+			}
+
+			resp.SetHeader(k, v)
+		},
+		Body: resp.Body,
+	}
 }
 
 func imageNotFound(
@@ -350,7 +593,7 @@ func imageNotFound(
 	resp.SetHeader("Cache-Control",
 		"public, no-cache, no-store, must-revalidate")
 
-	err4 := Strip(image, resp.Body, -1)
+	err4 := Strip(image, resp.Body, -1, vips.ImageTypeUnknown, nil)
 
 	if err4 != nil {
 		writeError(resp, err4)
@@ -358,6 +601,23 @@ func imageNotFound(
 	}
 }
 
+// Writes the error of a transformation,
+// according its type if it's an invalid option or a limit one.
+func transformError(resp *ImageResponse, err error) {
+	var invalid *InvalidOptionError
+	var limit *LimitError
+
+	if errors.As(err, &invalid) {
+		badRequest(resp, invalid.Error())
+	} else if !errors.As(err, &limit) {
+		writeError(resp, err)
+	} else if limit.status == 413 {
+		entityTooLarge(resp, limit.Error())
+	} else {
+		badRequest(resp, limit.Error())
+	}
+}
+
 func writeError(resp *ImageResponse, err error) {
 	resp.SetStatusCode(500)
 
@@ -389,3 +649,23 @@ func forbidden(resp *ImageResponse, msg string) {
 
 	fmt.Fprintf(resp.Body, msg)
 }
+
+func gone(resp *ImageResponse, msg string) {
+	resp.SetStatusCode(410)
+
+	log.Printf("WARNING: Gone: %s\n", msg)
+
+	resp.SetHeader("Content-Type", "text/plain")
+
+	fmt.Fprintf(resp.Body, msg)
+}
+
+func entityTooLarge(resp *ImageResponse, msg string) {
+	resp.SetStatusCode(413)
+
+	log.Printf("WARNING: Entity too large: %s\n", msg)
+
+	resp.SetHeader("Content-Type", "text/plain")
+
+	fmt.Fprintf(resp.Body, msg)
+}
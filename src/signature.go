@@ -0,0 +1,112 @@
+package nuggan
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * Returns a function that signs a given transformation `path`
+ * (e.g. `0/0/-/-/128/-/-/_2_L3BvcHRvY2F0X3YyLnBuZw==`),
+ * using the first configured secret.
+ *
+ * The signature is the unpadded base64url representation
+ * of the HMAC-SHA256 of the path.
+ */
+func SignPath(config Config) func(string) string {
+	var secret []byte = nil
+
+	if len(config.Secrets) > 0 {
+		secret = []byte(config.Secrets[0])
+	}
+
+	return func(path string) string {
+		return base64.RawURLEncoding.EncodeToString(hmacSum(secret, path))
+	}
+}
+
+/**
+ * Returns a function that checks the `signature` of a transformation `path`
+ * (as produced by `SignPath`) against any of the configured secrets,
+ * so that secrets can be rotated.
+ */
+func VerifyPath(config Config) func(string, string) bool {
+	secrets := make([][]byte, len(config.Secrets))
+
+	for i, s := range config.Secrets {
+		secrets[i] = []byte(s)
+	}
+
+	return func(signature string, path string) bool {
+		sig, err := base64.RawURLEncoding.DecodeString(signature)
+
+		if err != nil {
+			return false
+		}
+
+		for _, secret := range secrets {
+			if hmac.Equal(sig, hmacSum(secret, path)) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+/**
+ * Returns a function that signs a given transformation `path`
+ * so that it expires at the given time.
+ *
+ * The result is the signature segment `:expires.:signature`,
+ * where `expires` is a Unix timestamp (in seconds),
+ * and `signature` is computed as with `SignPath` for `:expires/:path`.
+ */
+func SignExpiringPath(config Config) func(string, time.Time) string {
+	sign := SignPath(config)
+
+	return func(path string, expires time.Time) string {
+		ts := expires.Unix()
+
+		return fmt.Sprintf("%d.%s", ts, sign(expiringPath(ts, path)))
+	}
+}
+
+// ---
+
+// Parses a signature segment, either `:signature` or `:expires.:signature`
+// (with expires = -1 if none).
+func parseSignature(segment string) (string, int64, error) {
+	idx := strings.Index(segment, ".")
+
+	if idx == -1 {
+		return segment, -1, nil
+	}
+
+	expires, err := strconv.ParseInt(segment[:idx], 10, 64)
+
+	if err != nil || expires < 0 {
+		return "", -1, errors.New(fmt.Sprintf(
+			"Invalid signature expiry: %s", segment[:idx]))
+	}
+
+	return segment[idx+1:], expires, nil
+}
+
+func expiringPath(expires int64, path string) string {
+	return fmt.Sprintf("%d/%s", expires, path)
+}
+
+func hmacSum(secret []byte, path string) []byte {
+	mac := hmac.New(sha256.New, secret)
+
+	mac.Write([]byte(path))
+
+	return mac.Sum(nil)
+}
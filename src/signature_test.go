@@ -0,0 +1,111 @@
+package nuggan
+
+import (
+	"testing"
+	"time"
+)
+
+var signedConfig = Config{
+	GroupedBaseUrls: config1.GroupedBaseUrls,
+	Secrets:         []string{"current", "previous"},
+}
+
+var sign1 = SignPath(signedConfig)
+var verify1 = VerifyPath(signedConfig)
+
+const signedPath1 = "0/0/-/-/128/-/-/_1_L29jdGljb25zLzEwMjQvbWFyay1naXRodWItNTEyLnBuZw=="
+
+func TestSignPath(t *testing.T) {
+	got := sign1(signedPath1)
+	expected := "HAEbPh9p_sTskbfGphvcPCT9ERt-eUSceI9_1Yx0D2k"
+
+	if got != expected {
+		t.Errorf("%s != %s\n", got, expected)
+	}
+}
+
+func TestVerifyPath(t *testing.T) {
+	if !verify1(sign1(signedPath1), signedPath1) {
+		t.Error("Signature must be verified")
+	}
+}
+
+func TestVerifyPathPreviousSecret(t *testing.T) {
+	previous := SignPath(Config{Secrets: []string{"previous"}})
+
+	if !verify1(previous(signedPath1), signedPath1) {
+		t.Error("Signature with previous secret must be verified")
+	}
+}
+
+func TestVerifyPathUnknownSecret(t *testing.T) {
+	other := SignPath(Config{Secrets: []string{"other"}})
+
+	if verify1(other(signedPath1), signedPath1) {
+		t.Error("Signature with unknown secret must be refused")
+	}
+}
+
+func TestVerifyPathTampered(t *testing.T) {
+	signature := sign1(signedPath1)
+
+	if verify1(signature, "0/0/-/-/1024/-/-/_1_L29jdGljb25zLzEwMjQvbWFyay1naXRodWItNTEyLnBuZw==") {
+		t.Error("Signature must be refused for another transformation")
+	}
+
+	if verify1("invalid*base64", signedPath1) {
+		t.Error("Malformed signature must be refused")
+	}
+}
+
+func TestSignExpiringPath(t *testing.T) {
+	signExpiring := SignExpiringPath(signedConfig)
+	expires := time.Unix(1767225600, 0)
+
+	got := signExpiring(signedPath1, expires)
+	expected := "1767225600." + sign1("1767225600/"+signedPath1)
+
+	if got != expected {
+		t.Errorf("%s != %s\n", got, expected)
+	}
+
+	signature, ts, err := parseSignature(got)
+
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if ts != 1767225600 {
+		t.Errorf("Unexpected expiry: %d", ts)
+	}
+
+	if !verify1(signature, expiringPath(ts, signedPath1)) {
+		t.Error("Expiring signature must be verified")
+	}
+
+	if verify1(signature, expiringPath(ts+3600, signedPath1)) {
+		t.Error("Signature must be refused for another expiry")
+	}
+}
+
+func TestParseSignatureWithoutExpiry(t *testing.T) {
+	signature, ts, err := parseSignature("HAEbPh9p_sTskbfGphvcPCT9ERt-eUSceI9_1Yx0D2k")
+
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if signature != "HAEbPh9p_sTskbfGphvcPCT9ERt-eUSceI9_1Yx0D2k" || ts != -1 {
+		t.Errorf("Unexpected signature: %s, %d", signature, ts)
+	}
+}
+
+func TestParseSignatureInvalidExpiry(t *testing.T) {
+	_, _, err := parseSignature("soon.HAEbPh9p_sTskbfGphvcPCT9ERt-eUSceI9_1Yx0D2k")
+
+	expected := "Invalid signature expiry: soon"
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error '%s': %v", expected, err)
+	}
+}
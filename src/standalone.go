@@ -12,8 +12,10 @@ func standaloneHandler(conf Config) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		request := ImageRequest{
 			Path:    req.URL.Path,
+			Query:   req.URL.Query(),
 			Method:  req.Method,
 			Referer: httpReferer(req),
+			Header:  req.Header,
 		}
 
 		headers := w.Header()
@@ -0,0 +1,339 @@
+package nuggan
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/davidbyttow/govips/pkg/vips"
+	"html"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Text rendered over an image, once resized, filtered & watermarked.
+//
+// The zero values (other than `Text`) are defaulted.
+type TextOverlay struct {
+	Text     string  // plain text (not markup), possibly multiline
+	Font     string  // font family & style (e.g. "DejaVu Sans Bold"), defaulted to 'sans'
+	Size     int     // font size in pixels, defaulted to 32
+	Color    string  // hex RGB or RGBA (e.g. "ffffff80"), defaulted to black
+	Position Gravity // defaulted to `GravityCenter`
+	Width    int     // in pixels, after which the lines are wrapped; 0 for the image width
+}
+
+const defaultFont = "sans"
+
+const defaultTextSize = 32
+
+// Maximum size of the font, in pixels
+const maxTextSize = 512
+
+// Maximum number of characters of a text overlay
+const maxTextLength = 512
+
+// Font description, as family name & style words
+var fontPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 _-]*$`)
+
+// Checks the text settings.
+func (t TextOverlay) validate() error {
+	if t.Text == "" || !utf8.ValidString(t.Text) {
+		return invalidOption("text", "Invalid text: expected UTF-8")
+	}
+
+	if n := utf8.RuneCountInString(t.Text); n > maxTextLength {
+		return invalidOption("text",
+			"Text too long: %d > %d characters", n, maxTextLength)
+	}
+
+	if t.Font != "" && !fontPattern.MatchString(t.Font) {
+		return invalidOption("font", "Invalid font '%s'", t.Font)
+	}
+
+	if t.Size < 0 || t.Size > maxTextSize {
+		return invalidOption("textsize",
+			"Invalid text size '%d': expected 1 to %d", t.Size, maxTextSize)
+	}
+
+	if t.Color != "" {
+		if _, err := parseHexColor(t.Color); err != nil {
+			return invalidOption("textcolor",
+				"Invalid text color '%s': %s", t.Color, err)
+		}
+	}
+
+	if !isCompassGravity(t.Position) {
+		return invalidOption("textpos",
+			"Unsupported text position '%s': expected center, north, northeast, east, southeast, south, southwest, west or northwest", t.Position)
+	}
+
+	if t.Width < 0 {
+		return invalidOption("textwidth",
+			"Invalid text width '%d': expected >= 0", t.Width)
+	}
+
+	return nil
+}
+
+// Renders the text over the image at its position,
+// wrapped at the maximum width (at most the image width).
+//
+// The text lines are aligned according the position
+// (e.g. on the left for `GravityWest`).
+func TextImage(image *vips.ImageRef, text TextOverlay) error {
+	if err := text.validate(); err != nil {
+		return err
+	}
+
+	c := color.NRGBA{0, 0, 0, 255}
+
+	if text.Color != "" {
+		c, _ = parseHexColor(text.Color)
+	}
+
+	font := text.Font
+	size := text.Size
+	position := text.Position
+
+	if font == "" {
+		font = defaultFont
+	}
+
+	if size == 0 {
+		size = defaultTextSize
+	}
+
+	if position == "" {
+		position = GravityCenter
+	}
+
+	iw := image.Width()
+	ih := image.Height()
+	width := iw
+
+	if text.Width > 0 && text.Width < iw {
+		width = text.Width
+	}
+
+	// ---
+
+	// Rendered as a mask (1 band, from 0 to 255),
+	// with a size in points equal to pixels at 72 DPI
+	rendered, err := vips.Text(html.EscapeString(text.Text),
+		vips.InputString("font", fmt.Sprintf("%s %d", font, size)),
+		vips.InputInt("width", width),
+		vips.InputInt("align", int(textAlign(position))),
+		vips.InputInt("dpi", 72))
+
+	if err != nil {
+		return err
+	}
+
+	mask := vips.NewImageRef(rendered, image.Format())
+
+	defer mask.Close()
+
+	tw := minInt(mask.Width(), iw)
+	th := minInt(mask.Height(), ih)
+
+	if tw != mask.Width() || th != mask.Height() {
+		cropped, err := vips.ExtractArea(mask.Image(), 0, 0, tw, th)
+
+		if err != nil {
+			return err
+		}
+
+		mask.SetImage(cropped)
+	}
+
+	// ---
+
+	fill, err := solidImage(tw, th, color.NRGBA{c.R, c.G, c.B, 255}, true)
+
+	if err != nil {
+		return err
+	}
+
+	defer fill.Close()
+
+	blank, err := solidImage(tw, th, color.NRGBA{c.R, c.G, c.B, 0}, true)
+
+	if err != nil {
+		return err
+	}
+
+	defer blank.Close()
+
+	// Color where the text is, transparent elsewhere (anti-aliased)
+	colored, err := vips.Ifthenelse(mask.Image(), fill.Image(), blank.Image(),
+		vips.InputBool("blend", true))
+
+	if err != nil {
+		return err
+	}
+
+	overlay := vips.NewImageRef(colored, image.Format())
+
+	defer func() { overlay.Close() }()
+
+	if c.A < 255 {
+		err := mapBands(overlay, nil, nil, nil, func(v int) int {
+			return v * int(c.A) / 255
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	left, top := watermarkOffsets(position, 0, iw, ih, tw, th)
+
+	return compositeOverlay(image, overlay, left, top)
+}
+
+// Returns the alignment of the text lines for the position.
+func textAlign(position Gravity) vips.Align {
+	switch position {
+	case GravityWest, GravityNorthWest, GravitySouthWest:
+		return vips.AlignLow
+
+	case GravityEast, GravityNorthEast, GravitySouthEast:
+		return vips.AlignHigh
+
+	default:
+		return vips.AlignCenter
+	}
+}
+
+// Parses a hex RGB or RGBA color (e.g. `ff0000` or `#ff000080`).
+func parseHexColor(value string) (color.NRGBA, error) {
+	c := color.NRGBA{A: 255}
+	b, err := hex.DecodeString(strings.TrimPrefix(value, "#"))
+
+	if err != nil || (len(b) != 3 && len(b) != 4) {
+		return c, errors.New(fmt.Sprintf(
+			"Invalid color '%s': expected hex RGB or RGBA", value))
+	}
+
+	c.R, c.G, c.B = b[0], b[1], b[2]
+
+	if len(b) == 4 {
+		c.A = b[3]
+	}
+
+	return c, nil
+}
+
+// Fontconfig files generated by `UseFontsDir`
+var fontsConfig struct {
+	mutex    sync.Mutex
+	dir      string // fonts directory
+	file     string // configuration file
+	cacheDir string
+}
+
+// Restricts the fonts of the text overlays to those of the local directory,
+// by generating a fontconfig file (`FONTCONFIG_FILE`),
+// so that no system font is required.
+//
+// The generated files are reused if called again for the same directory,
+// or otherwise replaced.
+//
+// Must be called before any text is rendered.
+func UseFontsDir(dir string) error {
+	abs, err := filepath.Abs(dir)
+
+	if err != nil {
+		return err
+	}
+
+	if fi, err := os.Stat(abs); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return errors.New(fmt.Sprintf(
+			"Fonts directory expected: %s", abs))
+	}
+
+	fontsConfig.mutex.Lock()
+	defer fontsConfig.mutex.Unlock()
+
+	if fontsConfig.dir == abs {
+		return os.Setenv("FONTCONFIG_FILE", fontsConfig.file)
+	}
+
+	// ---
+
+	cacheDir, err := ioutil.TempDir("", "nuggan-fontcache")
+
+	if err != nil {
+		return err
+	}
+
+	file, err := writeFontsConfig(abs, cacheDir)
+
+	if err != nil {
+		os.RemoveAll(cacheDir)
+		return err
+	}
+
+	if err := os.Setenv("FONTCONFIG_FILE", file); err != nil {
+		os.Remove(file)
+		os.RemoveAll(cacheDir)
+
+		return err
+	}
+
+	if fontsConfig.file != "" {
+		os.Remove(fontsConfig.file)
+		os.RemoveAll(fontsConfig.cacheDir)
+	}
+
+	fontsConfig.dir = abs
+	fontsConfig.file = file
+	fontsConfig.cacheDir = cacheDir
+
+	return nil
+}
+
+// Writes a fontconfig file only using the fonts of the directory,
+// and returns its path.
+func writeFontsConfig(dir string, cacheDir string) (string, error) {
+	conf, err := ioutil.TempFile("", "nuggan-fonts*.conf")
+
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+
+	buf.WriteString(`<?xml version="1.0"?>
+<!DOCTYPE fontconfig SYSTEM "fonts.dtd">
+<fontconfig>
+  <dir>`)
+
+	xml.EscapeText(buf, []byte(dir))
+	buf.WriteString("</dir>\n  <cachedir>")
+	xml.EscapeText(buf, []byte(cacheDir))
+	buf.WriteString("</cachedir>\n</fontconfig>\n")
+
+	_, err = conf.Write(buf.Bytes())
+
+	if cerr := conf.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(conf.Name())
+		return "", err
+	}
+
+	return conf.Name(), nil
+}
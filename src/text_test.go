@@ -0,0 +1,196 @@
+package nuggan
+
+import (
+	"bytes"
+	"image/color"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/davidbyttow/govips/pkg/vips"
+)
+
+func TestParseText(t *testing.T) {
+	params, err := parseParams("text:SGVsbG8gV29ybGQ,font:DejaVu Sans Bold,textsize:48,textcolor:FFFFFF80,textpos:south,textwidth:300")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := TextOverlay{
+		Text:     "Hello World",
+		Font:     "DejaVu Sans Bold",
+		Size:     48,
+		Color:    "ffffff80",
+		Position: GravitySouth,
+		Width:    300,
+	}
+
+	if params.Text == nil || *params.Text != expected {
+		t.Errorf("%v != %v", params.Text, expected)
+	}
+
+	if repr := params.String(); repr != "font:DejaVu Sans Bold,text:SGVsbG8gV29ybGQ,textcolor:ffffff80,textpos:south,textsize:48,textwidth:300" {
+		t.Errorf("Unexpected representation: %s", repr)
+	}
+
+	for _, segment := range []string{
+		"textsize:48",
+		"text:!",
+		"text:SGVsbG8,font:<b>",
+		"text:SGVsbG8,textsize:1000",
+		"text:SGVsbG8,textcolor:red",
+		"text:SGVsbG8,textpos:smart",
+		"text:SGVsbG8,textwidth:-1",
+	} {
+		if _, err := parseParams(segment); err == nil {
+			t.Errorf("Invalid text must be refused: %s", segment)
+		}
+	}
+}
+
+func TestParseHexColor(t *testing.T) {
+	for value, expected := range map[string]color.NRGBA{
+		"ff8000":    {255, 128, 0, 255},
+		"#FF800080": {255, 128, 0, 128},
+	} {
+		c, err := parseHexColor(value)
+
+		if err != nil {
+			t.Errorf("%s: %s", value, err)
+		} else if c != expected {
+			t.Errorf("%s: %v != %v", value, c, expected)
+		}
+	}
+
+	for _, value := range []string{"", "fff", "ff80", "gg8000", "ff800080ff"} {
+		if _, err := parseHexColor(value); err == nil {
+			t.Errorf("Invalid color must be refused: %s", value)
+		}
+	}
+}
+
+func TestTextImage(t *testing.T) {
+	noise, err := vips.Gaussnoise(200, 100)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	img := vips.NewImageRef(noise, vips.ImageTypeJPEG)
+
+	err = TextImage(img, TextOverlay{
+		Text:     "Hello World",
+		Color:    "ffffff80",
+		Position: GravitySouthWest,
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if img.Width() != 200 || img.Height() != 100 || img.Bands() != 3 {
+		t.Errorf("Unexpected image: %dx%d (%d bands)",
+			img.Width(), img.Height(), img.Bands())
+	}
+
+	if err := TextImage(img, TextOverlay{}); err == nil {
+		t.Error("Empty text must be refused")
+	}
+}
+
+func TestUseFontsDir(t *testing.T) {
+	previous, set := os.LookupEnv("FONTCONFIG_FILE")
+
+	defer func() {
+		if set {
+			os.Setenv("FONTCONFIG_FILE", previous)
+		} else {
+			os.Unsetenv("FONTCONFIG_FILE")
+		}
+	}()
+
+	dir, err := ioutil.TempDir("", "fonts")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := UseFontsDir(dir); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	file := os.Getenv("FONTCONFIG_FILE")
+	conf, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !strings.Contains(string(conf), "<dir>"+dir+"</dir>") {
+		t.Errorf("Fonts directory expected in configuration: %s", conf)
+	}
+
+	// Reused for the same directory
+	if err := UseFontsDir(dir); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if reused := os.Getenv("FONTCONFIG_FILE"); reused != file {
+		t.Errorf("Configuration must be reused: %s != %s", reused, file)
+	}
+
+	// Replaced for another one
+	other, err := ioutil.TempDir("", "fonts")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer os.RemoveAll(other)
+
+	if err := UseFontsDir(other); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer os.Remove(os.Getenv("FONTCONFIG_FILE"))
+
+	if _, err := os.Stat(file); err == nil {
+		t.Errorf("Previous configuration must be removed: %s", file)
+	}
+
+	if err := UseFontsDir(os.Getenv("FONTCONFIG_FILE")); err == nil {
+		t.Error("Fonts directory must be refused if not a directory")
+	}
+}
+
+func TestTextRefusedWithoutFontsDir(t *testing.T) {
+	conf, err := LoadConfig(strings.NewReader(`
+groupedBaseUrls = [ [ "https://octodex.github.com/images" ] ]
+`))
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	conf.FontsDir = "/nonexistent/fonts"
+	status := 200
+
+	Service(conf)(&ImageRequest{
+		Path:   "/optimg/0/0/-/-/-/-/-/text:SGVsbG8/_0_L3BvcHRvY2F0X3YyLnBuZw==",
+		Method: "GET",
+		Header: http.Header{},
+	}, &ImageResponse{
+		SetStatusCode: func(code int) { status = code },
+		SetHeader:     func(string, string) {},
+		Body:          new(bytes.Buffer),
+	})
+
+	if status != 500 {
+		t.Errorf("Text overlay must be refused without its fonts: %d", status)
+	}
+}
@@ -0,0 +1,525 @@
+package nuggan
+
+import (
+	"context"
+	"fmt"
+	"github.com/davidbyttow/govips/pkg/vips"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Options of an image transformation, applied in order:
+// orientation (according the EXIF metadata, then `Rotate` & flip),
+// crop, resize (if `ResizeWidth` > 0) and encoding.
+//
+// The zero value keeps the image as is (only oriented & stripped).
+type TransformOptions struct {
+	Rotate         int  // clockwise: 0, 90, 180 or 270 degrees
+	FlipHorizontal bool // mirror
+	FlipVertical   bool
+
+	X          CropValue // crop origin X
+	Y          CropValue // crop origin Y
+	CropWidth  CropValue // `NoCropValue` (or zero) if none
+	CropHeight CropValue // `NoCropValue` (or zero) if none
+
+	ResizeWidth  int  // not resized if 0
+	ResizeHeight int  // 0 if none
+	Upscale      bool // whether the image can be enlarged
+
+	Fit        Fit     // defaulted to `FitInside`
+	Gravity    Gravity // if specified, crop X & Y are ignored
+	Dpr        float64 // device pixel ratio, 0 if none
+	Background string  // hex RGB or RGBA, for the padding & the flattening (none if empty)
+
+	Filters   Filters      // applied once resized
+	Watermark *Watermark   // composited once filtered, nil if none
+	Text      *TextOverlay // rendered once watermarked, nil if none
+
+	Compression int            // 0 if none (default compression)
+	Quality     int            // from 1 to 100 (JPEG & WebP), 0 if none (default quality)
+	Format      vips.ImageType // `vips.ImageTypeUnknown` to keep the source format
+
+	Limits LimitsConfig // not limited if zero
+}
+
+// Maximum encoding quality
+const maxQuality = 100
+
+// Result of an image transformation
+type TransformResult struct {
+	Format vips.ImageType // effective output format
+	Dpr    float64        // effective device pixel ratio
+}
+
+// Error raised when a transformation option is not valid
+type InvalidOptionError struct {
+	Option string // name of the invalid option
+	msg    string
+}
+
+func (e *InvalidOptionError) Error() string {
+	return e.msg
+}
+
+func invalidOption(option string, format string, args ...interface{}) error {
+	return &InvalidOptionError{option, fmt.Sprintf(format, args...)}
+}
+
+// Parses the transformation path segments, from `:cropX` to the optional
+// `:params` (e.g. `["0", "0", "-", "-", "320", "-", "7", "fit:cover"]`).
+//
+// The output format and the limits are not part of the path,
+// so are left unspecified.
+func ParseTransformation(segments []string) (TransformOptions, error) {
+	opts := TransformOptions{}
+
+	if len(segments) != 7 && len(segments) != 8 {
+		return opts, invalidOption("path", "Invalid transformation path '%s': expected :cropX/:cropY/:cropWidth/:cropHeight/:resizeWidth/:resizeHeight/:compressionLevel(/:params)", strings.Join(segments, "/"))
+	}
+
+	if len(segments) == 8 {
+		params, err := parseParams(segments[7])
+
+		if err != nil {
+			return opts, err
+		}
+
+		opts.Fit = params.Fit
+		opts.Gravity = params.Gravity
+		opts.Dpr = params.Dpr
+		opts.Background = params.Background
+		opts.Rotate = params.Rotate
+		opts.FlipHorizontal = params.FlipH
+		opts.FlipVertical = params.FlipV
+		opts.Filters = params.Filters
+		opts.Watermark = params.Watermark
+		opts.Text = params.Text
+		opts.Quality = params.Quality
+	}
+
+	// crop x offset (mandatory)
+	x, err := ParseCropValue(segments[0], false)
+
+	if err != nil {
+		return opts, invalidOption(
+			"cropX", "Invalid crop x offset: %s", err.Error())
+	}
+
+	opts.X = x
+
+	// crop y offset (mandatory)
+	y, err := ParseCropValue(segments[1], false)
+
+	if err != nil {
+		return opts, invalidOption(
+			"cropY", "Invalid crop y offset: %s", err.Error())
+	}
+
+	opts.Y = y
+
+	// crop width
+	opts.CropWidth = NoCropValue
+
+	if segments[2] != "-" {
+		w, err := ParseCropValue(segments[2], true)
+
+		if err != nil {
+			return opts, invalidOption(
+				"cropWidth", "Invalid crop width: %s", err.Error())
+		}
+
+		opts.CropWidth = w
+	}
+
+	// crop height
+	opts.CropHeight = NoCropValue
+
+	if segments[3] != "-" {
+		h, err := ParseCropValue(segments[3], true)
+
+		if err != nil {
+			return opts, invalidOption(
+				"cropHeight", "Invalid crop height: %s", err.Error())
+		}
+
+		opts.CropHeight = h
+	}
+
+	// resize width, suffixed with '+' to allow enlargement
+	opts.Upscale = strings.HasSuffix(segments[4], "+")
+
+	if segments[4] != "-" {
+		w, err := strconv.Atoi(strings.TrimSuffix(segments[4], "+"))
+
+		if err != nil {
+			return opts, invalidOption(
+				"resizeWidth", "Invalid resize width '%s': %v",
+				segments[4], err.Error())
+		}
+
+		opts.ResizeWidth = w
+	}
+
+	// resize height
+	if segments[5] != "-" {
+		h, err := strconv.Atoi(segments[5])
+
+		if err != nil {
+			return opts, invalidOption(
+				"resizeHeight", "Invalid resize height '%s': %v",
+				segments[5], err.Error())
+		}
+
+		opts.ResizeHeight = h
+	}
+
+	// compression level
+	if segments[6] != "-" {
+		cl, err := strconv.Atoi(segments[6])
+
+		if err != nil {
+			return opts, invalidOption(
+				"compressionLevel", "Invalid compression level '%s': %v",
+				segments[6], err.Error())
+		}
+
+		opts.Compression = cl
+	}
+
+	return opts, nil
+}
+
+// Checks the options, including against the limits.
+//
+// Returns either an `*InvalidOptionError`,
+// or a `*LimitError` if the output size exceeds the limits.
+func (o TransformOptions) Validate() error {
+	for _, c := range []struct {
+		option string
+		value  CropValue
+	}{
+		{"cropX", o.X},
+		{"cropY", o.Y},
+		{"cropWidth", o.CropWidth},
+		{"cropHeight", o.CropHeight},
+	} {
+		v := c.value
+
+		if v.Relative && (v.Fraction < 0 || v.Fraction > 1) {
+			return invalidOption(c.option,
+				"Invalid %s '%s': expected 0.0 to 1.0", c.option, v)
+		}
+
+		if !v.Relative && v.Pixels < 0 &&
+			(c.option == "cropX" || c.option == "cropY") {
+			return invalidOption(c.option,
+				"Invalid %s '%d': expected >= 0", c.option, v.Pixels)
+		}
+	}
+
+	if _, ok := rotations[o.Rotate]; !ok {
+		return invalidOption("rotate",
+			"Unsupported rotation '%d': expected 0, 90, 180 or 270", o.Rotate)
+	}
+
+	if o.ResizeWidth < 0 {
+		return invalidOption("resizeWidth",
+			"Invalid resize width '%d': expected >= 0", o.ResizeWidth)
+	}
+
+	if o.ResizeHeight < 0 {
+		return invalidOption("resizeHeight",
+			"Invalid resize height '%d': expected >= 0", o.ResizeHeight)
+	}
+
+	if _, ok := fitModes[string(o.Fit)]; !ok && o.Fit != "" {
+		return invalidOption("fit", "Unsupported fit mode '%s'", o.Fit)
+	}
+
+	if g, ok := gravities[string(o.Gravity)]; o.Gravity != "" && (!ok || g != o.Gravity) {
+		return invalidOption(
+			"gravity", "Unsupported gravity '%s'", o.Gravity)
+	}
+
+	if o.Background != "" {
+		if _, err := parseHexColor(o.Background); err != nil {
+			return invalidOption("bg",
+				"Invalid background '%s': %s", o.Background, err)
+		}
+	}
+
+	if o.Dpr < 0 {
+		return invalidOption("dpr",
+			"Invalid device pixel ratio '%v': expected > 0", o.Dpr)
+	}
+
+	if err := o.Filters.validate(); err != nil {
+		return err
+	}
+
+	if o.Watermark != nil {
+		if err := o.Watermark.validate(); err != nil {
+			return err
+		}
+	}
+
+	if o.Text != nil {
+		if err := o.Text.validate(); err != nil {
+			return err
+		}
+	}
+
+	if o.Compression < 0 {
+		return invalidOption("compressionLevel",
+			"Invalid compression level '%d': expected >= 0", o.Compression)
+	}
+
+	if o.Quality < 0 || o.Quality > maxQuality {
+		return invalidOption("quality",
+			"Invalid quality '%d': expected 1 to %d", o.Quality, maxQuality)
+	}
+
+	return checkOutputSize(o.Limits,
+		o.cropWidth().absolute(), o.cropHeight().absolute(),
+		o.resizeWidth(), o.resizeHeight(), o.dpr())
+}
+
+// Returns the parameters of the path `:params` segment,
+// with the device pixel ratio capped by the limits.
+func (o TransformOptions) params() transformParams {
+	return transformParams{
+		Fit:        o.Fit,
+		Gravity:    o.Gravity,
+		Dpr:        o.dpr(),
+		Background: o.Background,
+		Rotate:     o.Rotate,
+		FlipH:      o.FlipHorizontal,
+		FlipV:      o.FlipVertical,
+		Filters:    o.Filters,
+		Watermark:  o.Watermark,
+		Text:       o.Text,
+		Quality:    o.Quality,
+	}
+}
+
+// Returns the device pixel ratio, capped by the limits (0 if none).
+func (o TransformOptions) dpr() float64 {
+	if o.Dpr <= 0 {
+		return 0
+	}
+
+	return limitedDpr(o.Limits, o.Dpr)
+}
+
+func (o TransformOptions) cropWidth() CropValue {
+	return noneIfZero(o.CropWidth)
+}
+
+func (o TransformOptions) cropHeight() CropValue {
+	return noneIfZero(o.CropHeight)
+}
+
+// Returns the resize width, or -1 if none.
+func (o TransformOptions) resizeWidth() int {
+	if o.ResizeWidth <= 0 {
+		return -1
+	}
+
+	return o.ResizeWidth
+}
+
+// Returns the resize height, or -1 if none.
+func (o TransformOptions) resizeHeight() int {
+	if o.ResizeHeight <= 0 {
+		return -1
+	}
+
+	return o.ResizeHeight
+}
+
+// Returns the background color, nil if none.
+func (o TransformOptions) background() *color.NRGBA {
+	if o.Background == "" {
+		return nil
+	}
+
+	c, err := parseHexColor(o.Background)
+
+	if err != nil {
+		return nil
+	}
+
+	return &c
+}
+
+// Returns the compression level, or -1 if none.
+func (o TransformOptions) compression() int {
+	if o.Compression <= 0 {
+		return -1
+	}
+
+	return o.Compression
+}
+
+func noneIfZero(v CropValue) CropValue {
+	if !v.Relative && !v.FromEnd && v.Pixels == 0 {
+		return NoCropValue
+	}
+
+	return v
+}
+
+// Reads an image from the input, transforms it according the options
+// (see `TransformOptions`), and writes the result to the output.
+//
+// The options are validated first (see `TransformOptions.Validate`),
+// and the source dimensions are checked against the limits
+// before the image is decoded (as the effective output dimensions are).
+func Transform(
+	ctx context.Context,
+	input io.Reader,
+	opts TransformOptions,
+	output io.Writer) (*TransformResult, error) {
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Only the header is read until the pixels are processed
+	sourceImg, err := vips.LoadImage(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkSourceSize(opts.Limits, sourceImg); err != nil {
+		sourceImg.Close()
+		return nil, err
+	}
+
+	// Crop coordinates are relative to the image as displayed
+	err = AutoOrient(sourceImg)
+
+	if err == nil {
+		err = Orient(sourceImg,
+			opts.Rotate, opts.FlipHorizontal, opts.FlipVertical)
+	}
+
+	if err != nil {
+		sourceImg.Close()
+		return nil, err
+	}
+
+	var croppedImg *vips.ImageRef
+
+	if opts.Gravity != "" {
+		croppedImg, err = CropImageGravity(sourceImg,
+			opts.cropWidth().Resolve(sourceImg.Width()),
+			opts.cropHeight().Resolve(sourceImg.Height()), opts.Gravity)
+	} else {
+		croppedImg, err = CropImageRelative(sourceImg,
+			opts.X, opts.Y, opts.cropWidth(), opts.cropHeight())
+	}
+
+	if err != nil {
+		sourceImg.Close()
+		return nil, err
+	}
+
+	defer croppedImg.Close()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// ---
+
+	imgFmt := outputFormat(croppedImg, opts.Format)
+	resizeW := opts.resizeWidth()
+	resizeH := opts.resizeHeight()
+	dpr := 1.0
+
+	if opts.Dpr > 0 {
+		dpr = sourceDpr(opts.dpr(), opts.Upscale,
+			croppedImg.Width(), croppedImg.Height(), resizeW, resizeH)
+	}
+
+	// Either resized in place (returning 1), or scaled on output
+	scale := 1.0
+
+	if resizeW > 0 {
+		scale, err = fitImage(
+			croppedImg,
+			applyDpr(resizeW, dpr),
+			applyDpr(resizeH, dpr),
+			opts.Fit,
+			opts.Gravity,
+			maxScale(opts.Limits, opts.Upscale),
+			opts.background())
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Effective output dimensions (e.g. with a height derived
+	// from the aspect ratio), whereas only the requested ones are validated
+	err = checkOutputDimensions(opts.Limits,
+		int(math.Round(float64(croppedImg.Width())*scale)),
+		int(math.Round(float64(croppedImg.Height())*scale)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Filters.enabled() || opts.Watermark != nil || opts.Text != nil {
+		// Resized in place, to be filtered & overlaid before it's encoded
+		if scale != 1 {
+			resized, err := vips.Resize(croppedImg.Image(), scale)
+
+			if err != nil {
+				return nil, err
+			}
+
+			croppedImg.SetImage(resized)
+		}
+
+		err = FilterImage(croppedImg, opts.Filters)
+
+		if err == nil && opts.Watermark != nil {
+			err = WatermarkImage(croppedImg, *opts.Watermark)
+		}
+
+		if err == nil && opts.Text != nil {
+			err = TextImage(croppedImg, *opts.Text)
+		}
+
+		if err == nil {
+			err = encode(croppedImg, 1, opts.compression(), opts.Quality,
+				imgFmt, opts.background(), output)
+		}
+	} else if resizeW > 0 {
+		err = encode(croppedImg, scale, opts.compression(), opts.Quality,
+			imgFmt, opts.background(), output)
+	} else {
+		err = strip(croppedImg, output,
+			opts.compression(), opts.Quality, imgFmt, opts.background())
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &TransformResult{
+		Format: imgFmt,
+		Dpr:    dpr,
+	}, nil
+}
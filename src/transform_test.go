@@ -0,0 +1,138 @@
+package nuggan
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseTransformation(t *testing.T) {
+	opts, err := ParseTransformation(
+		strings.Split("10/-0.5/50p/-/320+/200/70/fit:cover,dpr:2,quality:80", "/"))
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if opts.X.Resolve(100) != 10 || opts.Y.Resolve(100) != 50 {
+		t.Errorf("Unexpected crop origin: %s/%s", opts.X, opts.Y)
+	}
+
+	if opts.CropWidth.Resolve(100) != 50 || opts.CropHeight != NoCropValue {
+		t.Errorf("Unexpected crop dimensions: %s/%s",
+			opts.CropWidth, opts.CropHeight)
+	}
+
+	if opts.ResizeWidth != 320 || opts.ResizeHeight != 200 || !opts.Upscale {
+		t.Errorf("Unexpected resize: %d/%d/%t",
+			opts.ResizeWidth, opts.ResizeHeight, opts.Upscale)
+	}
+
+	if opts.Compression != 70 || opts.Quality != 80 ||
+		opts.Fit != FitCover || opts.Dpr != 2 {
+		t.Errorf("Unexpected options: %v", opts)
+	}
+
+	if err := opts.Validate(); err != nil {
+		t.Errorf("Options must be valid: %s", err)
+	}
+}
+
+func TestParseInvalidTransformation(t *testing.T) {
+	for option, path := range map[string]string{
+		"path":             "0/0/-/-/-/-",
+		"cropX":            "a/0/-/-/-/-/-",
+		"cropHeight":       "0/0/-/101p/-/-/-",
+		"resizeWidth":      "0/0/-/-/a/-/-",
+		"compressionLevel": "0/0/-/-/-/-/x",
+		"fit":              "0/0/-/-/-/-/-/fit:crop",
+	} {
+		_, err := ParseTransformation(strings.Split(path, "/"))
+
+		if e, ok := err.(*InvalidOptionError); !ok || e.Option != option {
+			t.Errorf("%s: invalid option %s expected: %v", path, option, err)
+		}
+	}
+}
+
+func TestValidateTransformOptions(t *testing.T) {
+	if err := (TransformOptions{}).Validate(); err != nil {
+		t.Errorf("Zero options must be valid: %s", err)
+	}
+
+	for _, opts := range []TransformOptions{
+		{X: CropValue{Pixels: -2}},
+		{CropWidth: CropValue{Fraction: 1.5, Relative: true}},
+		{ResizeWidth: -1},
+		{ResizeHeight: -1},
+		{Fit: Fit("crop")},
+		{Gravity: Gravity("smart")},
+		{Dpr: -1},
+		{Compression: -1},
+		{Quality: 101},
+		{Rotate: 45},
+	} {
+		err := opts.Validate()
+
+		if _, ok := err.(*InvalidOptionError); !ok {
+			t.Errorf("Invalid options must be refused: %v (%v)", opts, err)
+		}
+	}
+
+	opts := TransformOptions{
+		ResizeWidth: 2048,
+		Limits:      LimitsConfig{MaxOutputWidth: 1024},
+	}
+
+	err := opts.Validate()
+
+	if e, ok := err.(*LimitError); !ok || e.StatusCode() != 400 {
+		t.Errorf("Limit error expected: %v", err)
+	}
+}
+
+func TestTransformInvalidOptions(t *testing.T) {
+	output := new(bytes.Buffer)
+
+	_, err := Transform(context.Background(),
+		strings.NewReader("not read"), TransformOptions{Dpr: -1}, output)
+
+	if _, ok := err.(*InvalidOptionError); !ok {
+		t.Errorf("Invalid options must be refused: %v", err)
+	}
+
+	if output.Len() != 0 {
+		t.Errorf("Nothing must be written: %d", output.Len())
+	}
+}
+
+func TestTransformDerivedOutputLimits(t *testing.T) {
+	for _, opts := range []TransformOptions{
+		// Height of 600 derived from the 1024x768 source
+		{ResizeWidth: 800, Limits: LimitsConfig{MaxOutputHeight: 500}},
+
+		// Not resized
+		{Limits: LimitsConfig{MaxOutputWidth: 1000}},
+	} {
+		input, err := os.Open("../test/image1.jpg")
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		output := new(bytes.Buffer)
+
+		_, err = Transform(context.Background(), input, opts, output)
+		input.Close()
+
+		if e, ok := err.(*LimitError); !ok || e.StatusCode() != 400 {
+			t.Errorf("Output exceeding the limits must be refused: %v", err)
+		}
+
+		if output.Len() != 0 {
+			t.Errorf("Nothing must be written: %d", output.Len())
+		}
+	}
+}
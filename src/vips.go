@@ -1,12 +1,16 @@
 package nuggan
 
 import (
+	"errors"
+	"fmt"
 	"github.com/davidbyttow/govips/pkg/vips"
 	quant "github.com/ultimate-guitar/go-imagequant"
 	"image"
+	"image/color"
 	"image/png"
 	"io"
 	"log"
+	"math"
 )
 
 // Reads an image from the input, and then crops it using the given parameters.
@@ -29,7 +33,25 @@ func Crop(
 		return nil, err1
 	}
 
-	// ---
+	return CropImage(image, x, y, width, height)
+}
+
+// Crops an image already loaded (e.g. once its dimensions are checked),
+// using the same parameters as `Crop`.
+//
+// The image is first rotated according its EXIF orientation
+// (see `AutoOrient`), so the crop applies to the image as displayed.
+func CropImage(
+	image *vips.ImageRef,
+	x int,
+	y int,
+	width int,
+	height int) (*vips.ImageRef, error) {
+
+	// Coordinates relative to the image as displayed
+	if err := AutoOrient(image); err != nil {
+		return nil, err
+	}
 
 	origWidth := image.Width()
 	origHeight := image.Height()
@@ -81,6 +103,194 @@ func Crop(
 	return image, nil
 }
 
+// Reads an image from the input, and then crops it
+// using the given coordinates & dimensions,
+// possibly relative to the image ones (see `ParseCropValue`).
+//
+// - input: Image reader
+// - x: Crop origin X
+// - y: Crop origin Y
+// - width: Crop width (or `NoCropValue`)
+// - height: Crop height (or `NoCropValue`)
+func CropRelative(
+	input io.Reader,
+	x CropValue,
+	y CropValue,
+	width CropValue,
+	height CropValue) (*vips.ImageRef, error) {
+
+	image, err := vips.LoadImage(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return CropImageRelative(image, x, y, width, height)
+}
+
+// Crops an image already loaded, using the same parameters as `CropRelative`.
+func CropImageRelative(
+	image *vips.ImageRef,
+	x CropValue,
+	y CropValue,
+	width CropValue,
+	height CropValue) (*vips.ImageRef, error) {
+
+	// Coordinates relative to the image as displayed
+	if err := AutoOrient(image); err != nil {
+		return nil, err
+	}
+
+	w := image.Width()
+	h := image.Height()
+
+	return CropImage(image,
+		x.Resolve(w), y.Resolve(h), width.Resolve(w), height.Resolve(h))
+}
+
+// Rotates the image according its EXIF orientation (if any),
+// so that it's displayed as expected once the metadata are stripped.
+//
+// As the orientation is then reset, the image is not rotated twice.
+func AutoOrient(image *vips.ImageRef) error {
+	oriented, err := vips.Autorot(image.Image())
+
+	if err != nil {
+		return err
+	}
+
+	image.SetImage(oriented)
+
+	return nil
+}
+
+// Clockwise rotation angles, in degrees
+var rotations = map[int]vips.Angle{
+	0:   vips.Angle0,
+	90:  vips.Angle90,
+	180: vips.Angle180,
+	270: vips.Angle270,
+}
+
+// Rotates the image, and then flips it.
+//
+// - image: In-memory image reference
+// - angle: Clockwise rotation (0, 90, 180 or 270 degrees)
+// - flipH: Whether to flip horizontally (mirror)
+// - flipV: Whether to flip vertically
+func Orient(image *vips.ImageRef, angle int, flipH bool, flipV bool) error {
+	rot, ok := rotations[angle]
+
+	if !ok {
+		return errors.New(fmt.Sprintf(
+			"Unsupported rotation %d: expected 0, 90, 180 or 270", angle))
+	}
+
+	if rot != vips.Angle0 {
+		rotated, err := vips.Rot(image.Image(), rot)
+
+		if err != nil {
+			return err
+		}
+
+		image.SetImage(rotated)
+	}
+
+	for _, flip := range []struct {
+		enabled   bool
+		direction vips.Direction
+	}{
+		{flipH, vips.DirectionHorizontal},
+		{flipV, vips.DirectionVertical},
+	} {
+		if !flip.enabled {
+			continue
+		}
+
+		flipped, err := vips.Flip(image.Image(), flip.direction)
+
+		if err != nil {
+			return err
+		}
+
+		image.SetImage(flipped)
+	}
+
+	return nil
+}
+
+// Part of an image which is kept when cropped to given dimensions
+type Gravity string
+
+const (
+	GravityCenter    Gravity = "center"
+	GravityNorth     Gravity = "north"
+	GravityNorthEast Gravity = "northeast"
+	GravityEast      Gravity = "east"
+	GravitySouthEast Gravity = "southeast"
+	GravitySouth     Gravity = "south"
+	GravitySouthWest Gravity = "southwest"
+	GravityWest      Gravity = "west"
+	GravityNorthWest Gravity = "northwest"
+
+	// Smart crop, keeping the area most likely to draw attention
+	// (according skin tones, saturation & edges)
+	GravityAttention Gravity = "attention"
+
+	// Smart crop, keeping the area with the highest entropy
+	GravityEntropy Gravity = "entropy"
+)
+
+// Values of the libvips `VipsInteresting` enum
+var interestingModes = map[Gravity]int{
+	GravityEntropy:   2,
+	GravityAttention: 3,
+}
+
+// Reads an image from the input, and then crops it to the given dimensions,
+// keeping the part according the gravity
+// (so that the source dimensions are not required).
+//
+// - input: Image reader
+// - width: Crop width (or -1 if none)
+// - height: Crop height (or -1 if none)
+// - gravity: Part of the image to be kept
+func CropGravity(
+	input io.Reader,
+	width int,
+	height int,
+	gravity Gravity) (*vips.ImageRef, error) {
+
+	image, err := vips.LoadImage(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return CropImageGravity(image, width, height, gravity)
+}
+
+// Crops an image already loaded, using the same parameters as `CropGravity`.
+func CropImageGravity(
+	image *vips.ImageRef,
+	width int,
+	height int,
+	gravity Gravity) (*vips.ImageRef, error) {
+
+	// Coordinates relative to the image as displayed
+	if err := AutoOrient(image); err != nil {
+		return nil, err
+	}
+
+	err := cropGravity(image, width, height, gravity)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return image, nil
+}
+
 // Scale down the given image (to a smaller size),
 // and write the result to the given writer.
 //
@@ -88,52 +298,144 @@ func Crop(
 // - width: Resize width; Ignored if > image width.
 // - height: Resize height; Ignored if < 0 or > image height.
 // - compression: Compression level (>= 0 && <= 9);  Ignored if < 0.
+// - format: Output format (or `vips.ImageTypeUnknown` to keep image format)
+// - background: Color the transparency is flattened with, if the format has no alpha channel; Ignored if nil.
 // - output: Result writer
 func ScaleDown(
 	image *vips.ImageRef,
 	width int,
 	height int,
 	compression int,
+	format vips.ImageType,
+	background *color.NRGBA,
 	output io.Writer) error {
 
-	rw := float64(width)
-	rh := float64(height)
+	return Resize(
+		image, width, height, FitInside, GravityCenter, 1,
+		compression, format, background, output)
+}
 
-	imgh := image.Height()
-	imgw := image.Width()
+// How an image is fitted into the requested dimensions
+type Fit string
 
-	ih := float64(imgh)
-	iw := float64(imgw)
+const (
+	// Within the dimensions, preserving the aspect ratio (default)
+	FitInside Fit = "inside"
 
-	imgTx := vips.NewTransform().Image(image)
+	// Covering the dimensions, preserving the aspect ratio
+	FitOutside Fit = "outside"
 
-	var scale float64 = 1
+	// Exactly the dimensions, cropping what's outside
+	FitCover Fit = "cover"
 
-	if (rh < 0 || rh <= ih) && rw <= iw {
-		ws := rw / iw
+	// Exactly the dimensions, letterboxing what's not covered
+	FitContain Fit = "contain"
 
-		var hs float64 = 1
+	// Exactly the dimensions, stretching the image
+	FitFill Fit = "fill"
+)
 
-		if rh > 0 {
-			hs = rh / ih
-		}
+// Resize the given image, and write the result to the given writer.
+//
+// - image: In-memory image reference
+// - width: Resize width
+// - height: Resize height; Ignored if < 0 (so only `FitInside` applies).
+// - fit: How the image is fitted into the dimensions
+// - gravity: Part of the image kept if cropped by `FitCover`
+// - maxScale: Maximum enlargement factor (if <= 1, the image is not enlarged, and a size greater than the image one is ignored).
+// - compression: Compression level (>= 0 && <= 9);  Ignored if < 0.
+// - format: Output format (or `vips.ImageTypeUnknown` to keep image format)
+// - background: Color of the padding for `FitContain`, and the one the transparency is flattened with if the format has no alpha channel; Ignored if nil.
+// - output: Result writer
+func Resize(
+	image *vips.ImageRef,
+	width int,
+	height int,
+	fit Fit,
+	gravity Gravity,
+	maxScale float64,
+	compression int,
+	format vips.ImageType,
+	background *color.NRGBA,
+	output io.Writer) error {
 
-		if ws < hs {
-			scale = ws
-		} else {
-			scale = hs
-		}
-	} else {
-		log.Printf("WARN: Scale defaults to %f: expected width(%f < %f) and height(%f < 0 or < %f)\n", scale, rh, ih, rw, iw)
+	// Orientation must be applied before it's stripped
+	if err := AutoOrient(image); err != nil {
+		return err
+	}
+
+	scale, err := fitImage(
+		image, width, height, fit, gravity, maxScale, background)
+
+	if err != nil {
+		return err
 	}
 
-	finalTx := imgTx.Scale(scale).StripMetadata()
+	return encode(image, scale, compression, 0, format, background, output)
+}
+
+// Resizes the given image in place (e.g. so that it can be filtered),
+// using the same parameters as `Resize`.
+func ResizeImage(
+	image *vips.ImageRef,
+	width int,
+	height int,
+	fit Fit,
+	gravity Gravity,
+	maxScale float64,
+	background *color.NRGBA) error {
+
+	if err := AutoOrient(image); err != nil {
+		return err
+	}
+
+	scale, err := fitImage(
+		image, width, height, fit, gravity, maxScale, background)
+
+	if err != nil || scale == 1 {
+		return err
+	}
+
+	resized, err := vips.Resize(image.Image(), scale)
+
+	if err != nil {
+		return err
+	}
+
+	image.SetImage(resized)
+
+	return nil
+}
+
+// Scales the image on output, stripping its metadata
+// (and flattening its transparency if the format has no alpha channel).
+//
+// The quality only applies to the lossy formats (JPEG & WebP),
+// and is ignored if <= 0.
+func encode(
+	image *vips.ImageRef,
+	scale float64,
+	compression int,
+	quality int,
+	format vips.ImageType,
+	background *color.NRGBA,
+	output io.Writer) error {
+
+	imgTx := vips.NewTransform().Image(image)
+
+	outFmt := outputFormat(image, format)
+	finalTx := flatten(imgTx.Scale(scale).StripMetadata().Format(outFmt),
+		outFmt, background)
 
 	if compression > 0 {
 		finalTx = imgTx.Compression(compression)
 	}
 
-	if image.Format() == vips.ImageTypePNG {
+	if quality > 0 {
+		finalTx = finalTx.Quality(quality)
+	}
+
+	if outFmt == vips.ImageTypePNG {
 		return pngCompress(finalTx, scale, compression, output)
 	}
 
@@ -144,16 +446,290 @@ func ScaleDown(
 	return err
 }
 
-// Only strips image (no other transformation).
-func Strip(image *vips.ImageRef, output io.Writer, compression int) error {
-	imgTx := vips.NewTransform().Image(image).StripMetadata()
+// Fits the image into the requested dimensions,
+// either resizing it in place (and returning 1),
+// or returning the scale to be applied on output.
+func fitImage(
+	image *vips.ImageRef,
+	width int,
+	height int,
+	fit Fit,
+	gravity Gravity,
+	maxScale float64,
+	background *color.NRGBA) (float64, error) {
+
+	rw := float64(width)
+	rh := float64(height)
+
+	ih := float64(image.Height())
+	iw := float64(image.Width())
+
+	ws := rw / iw
+	hs := ws
+
+	if rh > 0 {
+		hs = rh / ih
+	}
+
+	if rh <= 0 || fit == "" || fit == FitInside || fit == FitOutside {
+		scale := math.Min(ws, hs)
+
+		if fit == FitOutside {
+			scale = math.Max(ws, hs)
+		}
+
+		if maxScale <= 1 && (rh > ih || rw > iw) {
+			scale = 1
+
+			log.Printf("WARN: Scale defaults to %f: expected width(%f < %f) and height(%f < 0 or < %f)\n", scale, rh, ih, rw, iw)
+		} else if scale > maxScale && maxScale > 1 {
+			log.Printf("WARN: Scale %f capped to %f\n", scale, maxScale)
+
+			scale = maxScale
+		}
+
+		return scale, nil
+	}
+
+	// ---
+
+	limit := math.Max(maxScale, 1)
+
+	if fit == FitCover {
+		ws = math.Max(ws, hs)
+		hs = ws
+	} else if fit == FitContain {
+		ws = math.Min(ws, hs)
+		hs = ws
+	}
+
+	ws = math.Min(ws, limit)
+	hs = math.Min(hs, limit)
+
+	resized, err := vips.Resize(
+		image.Image(), ws, vips.InputDouble("vscale", hs))
+
+	if err != nil {
+		return 1, err
+	}
+
+	image.SetImage(resized)
+
+	if fit == FitCover {
+		// Smaller if not enlarged enough
+		return 1, cropGravity(image, width, height, gravity)
+	} else if fit == FitContain {
+		return 1, padCenter(image, width, height, background)
+	}
+
+	return 1, nil
+}
+
+// Crops the image to the given dimensions (or less if larger than the image),
+// according the gravity.
+func cropGravity(
+	image *vips.ImageRef,
+	width int,
+	height int,
+	gravity Gravity) error {
+
+	iw := image.Width()
+	ih := image.Height()
+
+	w := iw
+	h := ih
+
+	if width > 0 {
+		w = minInt(width, iw)
+	}
+
+	if height > 0 {
+		h = minInt(height, ih)
+	}
+
+	if w == iw && h == ih {
+		return nil
+	}
+
+	// ---
+
+	if interesting, ok := interestingModes[gravity]; ok {
+		cropped, err := vips.Smartcrop(image.Image(), w, h,
+			vips.InputInt("interesting", interesting))
+
+		if err != nil {
+			return err
+		}
+
+		image.SetImage(cropped)
+
+		return nil
+	}
+
+	left := (iw - w) / 2
+	top := (ih - h) / 2
+
+	switch gravity {
+	case GravityNorth, GravityNorthEast, GravityNorthWest:
+		top = 0
+
+	case GravitySouth, GravitySouthEast, GravitySouthWest:
+		top = ih - h
+	}
+
+	switch gravity {
+	case GravityWest, GravityNorthWest, GravitySouthWest:
+		left = 0
+
+	case GravityEast, GravityNorthEast, GravitySouthEast:
+		left = iw - w
+	}
+
+	cropped, err := vips.ExtractArea(image.Image(), left, top, w, h)
+
+	if err != nil {
+		return err
+	}
+
+	image.SetImage(cropped)
+
+	return nil
+}
+
+// Pads the image up to the given dimensions, keeping it centered
+// (with the background color if not nil, otherwise with a black background,
+// or a transparent one if it has an alpha channel).
+//
+// The alpha of the background only applies if the image has an alpha channel.
+func padCenter(
+	image *vips.ImageRef,
+	width int,
+	height int,
+	background *color.NRGBA) error {
+
+	w := maxInt(width, image.Width())
+	h := maxInt(height, image.Height())
+
+	if w == image.Width() && h == image.Height() {
+		return nil
+	}
+
+	left := (w - image.Width()) / 2
+	top := (h - image.Height()) / 2
+
+	if background == nil {
+		padded, err := vips.Embed(image.Image(), left, top, w, h,
+			vips.InputInt("extend", int(vips.ExtendBlack)))
+
+		if err != nil {
+			return err
+		}
+
+		image.SetImage(padded)
+
+		return nil
+	}
+
+	// ---
+
+	// 8 bits sRGB, as the canvas
+	rgb, err := vips.Colourspace(image.Image(), vips.InterpretationSRGB)
+
+	if err != nil {
+		return err
+	}
+
+	image.SetImage(rgb)
+
+	alpha := image.Bands() == 4
+	c := *background
+
+	if !alpha {
+		c.A = 255
+	}
+
+	canvas, err := solidImage(w, h, c, alpha)
+
+	if err != nil {
+		return err
+	}
+
+	defer canvas.Close()
+
+	padded, err := vips.Insert(canvas.Image(), image.Image(), left, top)
+
+	if err != nil {
+		return err
+	}
+
+	image.SetImage(padded)
+
+	return nil
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+// Only strips image (no other transformation),
+// possibly converting it to the given `format`
+// (unless `vips.ImageTypeUnknown`).
+//
+// If the format has no alpha channel, the transparency is flattened
+// with the `background` color (unless nil).
+func Strip(
+	image *vips.ImageRef,
+	output io.Writer,
+	compression int,
+	format vips.ImageType,
+	background *color.NRGBA) error {
+
+	return strip(image, output, compression, 0, format, background)
+}
+
+// Strips the image as `Strip`, with the given quality
+// for the lossy formats (ignored if <= 0).
+func strip(
+	image *vips.ImageRef,
+	output io.Writer,
+	compression int,
+	quality int,
+	format vips.ImageType,
+	background *color.NRGBA) error {
+
+	// Orientation must be applied before it's stripped
+	if err := AutoOrient(image); err != nil {
+		return err
+	}
+
+	outFmt := outputFormat(image, format)
+	imgTx := flatten(
+		vips.NewTransform().Image(image).StripMetadata().Format(outFmt),
+		outFmt, background)
+
 	finalTx := imgTx
 
 	if compression > 0 {
 		finalTx = imgTx.Compression(compression)
 	}
 
-	if image.Format() == vips.ImageTypePNG {
+	if quality > 0 {
+		finalTx = finalTx.Quality(quality)
+	}
+
+	if outFmt == vips.ImageTypePNG {
 		return pngCompress(finalTx, 1.0, -1, output)
 	}
 
@@ -164,6 +740,35 @@ func Strip(image *vips.ImageRef, output io.Writer, compression int) error {
 	return err
 }
 
+// Flattens the transparency with the background color (unless nil),
+// if the format has no alpha channel.
+func flatten(
+	imgTx *vips.Transform,
+	format vips.ImageType,
+	background *color.NRGBA) *vips.Transform {
+
+	if background == nil || alphaFormat(format) {
+		return imgTx
+	}
+
+	return imgTx.BackgroundColor(
+		vips.Color{R: background.R, G: background.G, B: background.B})
+}
+
+// Whether the format supports an alpha channel.
+func alphaFormat(format vips.ImageType) bool {
+	return format != vips.ImageTypeJPEG
+}
+
+// Returns the effective output format for the given image.
+func outputFormat(image *vips.ImageRef, format vips.ImageType) vips.ImageType {
+	if format == vips.ImageTypeUnknown {
+		return image.Format()
+	}
+
+	return format
+}
+
 // - imgTx: source transformation, to be outputed to the given writer
 // - output: Result writer
 func pngCompress(
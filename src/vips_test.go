@@ -1,9 +1,13 @@
 package nuggan
 
 import (
+	"bytes"
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
 	"io"
 	"io/ioutil"
 	"os"
@@ -53,6 +57,41 @@ func TestScaleDownNegativeHeight(t *testing.T) {
 	checkVipsResult(t, inputFile, expectedMd5, testIt)
 }
 
+func TestCropRotatedThenScaleDown(t *testing.T) {
+	// EXIF orientation 6: 1024x768 stored, displayed as 768x1024
+	input, err := os.Open("../test/image1-rotated.jpg")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer input.Close()
+
+	img, err := Crop(input, 0, 0, 700, 1000)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	output := new(bytes.Buffer)
+
+	err = ScaleDown(img, 350, -1, -1, vips.ImageTypeUnknown, nil, output)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	conf, _, err := image.DecodeConfig(output)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if conf.Width != 350 || conf.Height != 500 {
+		t.Errorf("Crop expected as displayed: %dx%d", conf.Width, conf.Height)
+	}
+}
+
 // ---
 
 func ScaleDownTest(width int, height int) func(io.Reader, io.Writer) error {
@@ -63,7 +102,8 @@ func ScaleDownTest(width int, height int) func(io.Reader, io.Writer) error {
 			return err
 		}
 
-		return ScaleDown(img, width, height, -1, output)
+		return ScaleDown(
+			img, width, height, -1, vips.ImageTypeUnknown, nil, output)
 	}
 }
 
@@ -132,3 +172,210 @@ func checkVipsResult(t *testing.T, inputFile string, expectedMd5 []string, testI
 		t.Errorf("MD5(%s) != MD5(%s)", res, expectedMd5)
 	}
 }
+
+func TestFitCover(t *testing.T) {
+	checkFit(t, FitCover, 50, 50, 50, 50)
+}
+
+func TestFitContain(t *testing.T) {
+	checkFit(t, FitContain, 50, 50, 50, 50)
+}
+
+func TestFitCoverWithoutEnlargement(t *testing.T) {
+	checkFit(t, FitCover, 400, 50, 200, 50)
+}
+
+func checkFit(
+	t *testing.T,
+	fit Fit,
+	width int,
+	height int,
+	expectedWidth int,
+	expectedHeight int) {
+
+	noise, err := vips.Gaussnoise(200, 100)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	image := vips.NewImageRef(noise, vips.ImageTypePNG)
+
+	if _, err := fitImage(image, width, height, fit, GravityCenter, 1, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if image.Width() != expectedWidth || image.Height() != expectedHeight {
+		t.Errorf("%s: %dx%d != %dx%d", fit,
+			image.Width(), image.Height(), expectedWidth, expectedHeight)
+	}
+}
+
+func TestCropGravity(t *testing.T) {
+	for _, gravity := range []Gravity{
+		GravityNorthEast, GravitySouth, GravityAttention,
+	} {
+		noise, err := vips.Gaussnoise(200, 100)
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		image, err := CropImageGravity(
+			vips.NewImageRef(noise, vips.ImageTypePNG), 50, -1, gravity)
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if image.Width() != 50 || image.Height() != 100 {
+			t.Errorf("%s: %dx%d != 50x100",
+				gravity, image.Width(), image.Height())
+		}
+	}
+}
+
+func TestCropRelative(t *testing.T) {
+	noise, err := vips.Gaussnoise(200, 100)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	x, _ := ParseCropValue("-50", false)
+	h, _ := ParseCropValue("50p", true)
+
+	image, err := CropImageRelative(vips.NewImageRef(noise, vips.ImageTypePNG),
+		x, CropValue{}, NoCropValue, h)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if image.Width() != 50 || image.Height() != 50 {
+		t.Errorf("%dx%d != 50x50", image.Width(), image.Height())
+	}
+}
+
+func TestPadCenterBackground(t *testing.T) {
+	noise, err := vips.Gaussnoise(200, 100)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	image := vips.NewImageRef(noise, vips.ImageTypeJPEG)
+	background := color.NRGBA{255, 255, 255, 128}
+
+	if err := padCenter(image, 300, 300, &background); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Kept opaque
+	if image.Width() != 300 || image.Height() != 300 || image.Bands() != 3 {
+		t.Errorf("Unexpected image: %dx%d (%d bands)",
+			image.Width(), image.Height(), image.Bands())
+	}
+}
+
+func TestAlphaFormat(t *testing.T) {
+	if alphaFormat(vips.ImageTypeJPEG) {
+		t.Error("JPEG must not support alpha")
+	}
+
+	for _, f := range []vips.ImageType{vips.ImageTypePNG, vips.ImageTypeWEBP} {
+		if !alphaFormat(f) {
+			t.Errorf("%s must support alpha", vips.ImageTypes[f])
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	for angle, expected := range map[int][2]int{
+		0:   {200, 100},
+		90:  {100, 200},
+		180: {200, 100},
+		270: {100, 200},
+	} {
+		noise, err := vips.Gaussnoise(200, 100)
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		image := vips.NewImageRef(noise, vips.ImageTypePNG)
+
+		if err := Orient(image, angle, true, true); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if image.Width() != expected[0] || image.Height() != expected[1] {
+			t.Errorf("%d: %dx%d != %v",
+				angle, image.Width(), image.Height(), expected)
+		}
+	}
+
+	noise, _ := vips.Gaussnoise(200, 100)
+
+	if err := Orient(vips.NewImageRef(noise, vips.ImageTypePNG), 45, false, false); err == nil {
+		t.Error("Unsupported rotation must be refused")
+	}
+}
+
+func TestFilterImage(t *testing.T) {
+	noise, err := vips.Gaussnoise(200, 100)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	image := vips.NewImageRef(noise, vips.ImageTypePNG)
+
+	err = FilterImage(image, Filters{
+		Blur:       2,
+		Sharpen:    0.5,
+		Brightness: 10,
+		Contrast:   -10,
+		Saturation: 20,
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if image.Width() != 200 || image.Height() != 100 || image.Bands() != 3 {
+		t.Errorf("Unexpected image: %dx%d (%d bands)",
+			image.Width(), image.Height(), image.Bands())
+	}
+
+	if err := Grayscale(image); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if image.Bands() != 1 {
+		t.Errorf("Grayscale image expected: %d bands", image.Bands())
+	}
+}
+
+func TestMakeLookupTable(t *testing.T) {
+	table := makeLookupTable(false, []func(int) int{
+		nil,
+		func(v int) int { return v * 2 },
+	})
+
+	for i, expected := range map[int][4]uint8{
+		0:   {0, 0, 0, 255},
+		100: {100, 200, 100, 255},
+		200: {200, 255, 200, 255},
+	} {
+		c := table.NRGBAAt(i, 0)
+
+		if got := [4]uint8{c.R, c.G, c.B, c.A}; got != expected {
+			t.Errorf("%d: %v != %v", i, got, expected)
+		}
+	}
+
+	if c := makeLookupTable(true, nil).NRGBAAt(10, 0); c.A != 10 {
+		t.Errorf("Alpha must be kept: %d", c.A)
+	}
+}
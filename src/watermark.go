@@ -0,0 +1,498 @@
+package nuggan
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/davidbyttow/govips/pkg/vips"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Watermark image, declared in the configuration
+// and requested by name with the `watermark` parameter
+type WatermarkConfig struct {
+	File     string  // local image file
+	Url      HttpUrl // or image URL, from a configured group
+	Position string  // gravity, defaulted to 'southeast'
+	Margin   int     // in pixels, from the image edges
+	Opacity  float64 // from 0 to 1, defaulted to 1
+	Size     float64 // relative to the image width, 0 to keep the watermark size
+}
+
+// Watermark composited over an image, once resized & filtered.
+//
+// The zero values (other than `Image`) are defaulted.
+type Watermark struct {
+	Name     string  // as requested
+	Image    []byte  // encoded watermark image (e.g. PNG)
+	Position Gravity // defaulted to `GravitySouthEast`
+	Margin   int     // in pixels, from the image edges
+	Opacity  float64 // from 0 to 1, defaulted to 1 if 0
+	Size     float64 // relative to the image width, 0 to keep the watermark size
+
+	// Whether the margin & size are specified, even if 0
+	// (rather than resolved from the configuration)
+	MarginSet bool
+	SizeSet   bool
+}
+
+// Checks the watermark settings (not its image).
+func (w Watermark) validate() error {
+	if !isCompassGravity(w.Position) {
+		return invalidOption("wmpos",
+			"Unsupported watermark position '%s': expected center, north, northeast, east, southeast, south, southwest, west or northwest", w.Position)
+	}
+
+	if w.Margin < 0 {
+		return invalidOption("wmmargin",
+			"Invalid watermark margin '%d': expected >= 0", w.Margin)
+	}
+
+	if w.Opacity < 0 || w.Opacity > 1 {
+		return invalidOption("wmopacity",
+			"Invalid watermark opacity '%v': expected 0.0 to 1.0", w.Opacity)
+	}
+
+	if w.Size < 0 || w.Size > 1 {
+		return invalidOption("wmsize",
+			"Invalid watermark size '%v': expected 0.0 to 1.0", w.Size)
+	}
+
+	return nil
+}
+
+// Checks the gravity is either empty or a compass direction
+// (not a smart one).
+func isCompassGravity(g Gravity) bool {
+	if g == "" {
+		return true
+	}
+
+	if v, ok := gravities[string(g)]; !ok || v != g {
+		return false
+	}
+
+	_, smart := interestingModes[g]
+
+	return !smart
+}
+
+// Reads the watermark image, and then composites it over the image
+// (resized if `Size` is specified) at its position,
+// with its opacity.
+//
+// A watermark larger than the image (minus the margins) is scaled down,
+// and a margin too large for the image is capped.
+func WatermarkImage(image *vips.ImageRef, watermark Watermark) error {
+	if len(watermark.Image) == 0 {
+		return invalidOption("watermark",
+			"No image for watermark '%s'", watermark.Name)
+	}
+
+	iw := image.Width()
+	ih := image.Height()
+	margin := watermarkMargin(watermark.Margin, iw, ih)
+
+	if margin != watermark.Margin {
+		log.Printf("WARN: Margin of watermark '%s' capped to %d for image %dx%d\n",
+			watermark.Name, margin, iw, ih)
+	}
+
+	overlay, err := vips.NewImageFromBuffer(watermark.Image)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() { overlay.Close() }()
+
+	// ---
+
+	ow := float64(overlay.Width())
+	oh := float64(overlay.Height())
+	scale := 1.0
+
+	if watermark.Size > 0 {
+		scale = watermark.Size * float64(iw) / ow
+	}
+
+	scale = math.Min(scale, math.Min(
+		float64(iw-2*margin)/ow, float64(ih-2*margin)/oh))
+
+	if scale != 1 {
+		resized, err := vips.Resize(overlay.Image(), scale)
+
+		if err != nil {
+			return err
+		}
+
+		overlay.SetImage(resized)
+	}
+
+	transparent, err := overlayAlpha(overlay, watermark.Opacity)
+
+	if err != nil {
+		return err
+	}
+
+	overlay.Close()
+	overlay = transparent
+
+	// ---
+
+	left, top := watermarkOffsets(watermark.Position, margin,
+		iw, ih, overlay.Width(), overlay.Height())
+
+	return compositeOverlay(image, overlay, left, top)
+}
+
+// Composites the overlay (with an alpha band) over the image,
+// at the given offsets.
+//
+// The image is kept opaque if it was.
+func compositeOverlay(
+	image *vips.ImageRef,
+	overlay *vips.ImageRef,
+	left int,
+	top int) error {
+
+	embedded, err := vips.Embed(overlay.Image(), left, top,
+		image.Width(), image.Height(),
+		vips.InputInt("extend", int(vips.ExtendBlack)))
+
+	if err != nil {
+		return err
+	}
+
+	overlay.SetImage(embedded)
+
+	alpha := image.Bands() == 2 || image.Bands() == 4
+
+	if err := image.Composite(overlay, vips.BlendModeOver); err != nil {
+		return err
+	}
+
+	composited, err := vips.Cast(image.Image(), vips.BandFormatUchar)
+
+	if err != nil {
+		return err
+	}
+
+	image.SetImage(composited)
+
+	if alpha {
+		return nil
+	}
+
+	// Alpha added by the compositing, whereas the image is opaque
+	opaque, err := vips.ExtractBand(image.Image(), 0,
+		vips.InputInt("n", image.Bands()-1))
+
+	if err != nil {
+		return err
+	}
+
+	image.SetImage(opaque)
+
+	return nil
+}
+
+// Returns the margin, capped so that there is room for the watermark
+// (at least 1 pixel), so that it can't be avoided with a too large margin.
+func watermarkMargin(margin int, width int, height int) int {
+	max := (minInt(width, height) - 1) / 2
+
+	if margin > max {
+		return maxInt(max, 0)
+	}
+
+	return margin
+}
+
+// Returns the overlay with an alpha band (so that it's transparent
+// once embedded), multiplied by the opacity (unless 0).
+func overlayAlpha(
+	overlay *vips.ImageRef,
+	opacity float64) (*vips.ImageRef, error) {
+
+	// Composited over a transparent canvas, which adds the alpha if missing
+	canvas, err := solidImage(overlay.Width(), overlay.Height(),
+		color.NRGBA{0, 0, 0, 0}, true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := canvas.Composite(overlay, vips.BlendModeOver); err != nil {
+		canvas.Close()
+		return nil, err
+	}
+
+	// 8 bits per band, so that the alpha can be mapped
+	casted, err := vips.Cast(canvas.Image(), vips.BandFormatUchar)
+
+	if err != nil {
+		canvas.Close()
+		return nil, err
+	}
+
+	canvas.SetImage(casted)
+
+	if opacity <= 0 || opacity >= 1 {
+		return canvas, nil
+	}
+
+	err = mapBands(canvas, nil, nil, nil, func(v int) int {
+		return int(math.Round(float64(v) * opacity))
+	})
+
+	if err != nil {
+		canvas.Close()
+		return nil, err
+	}
+
+	return canvas, nil
+}
+
+// Returns an image of the given dimensions, filled with the color
+// (with an alpha band if `alpha` or if the color is not opaque).
+func solidImage(
+	width int,
+	height int,
+	c color.NRGBA,
+	alpha bool) (*vips.ImageRef, error) {
+
+	// 2nd pixel transparent if `alpha`, so that it's encoded with 4 bands
+	pixels := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	pixels.SetNRGBA(0, 0, c)
+
+	if !alpha {
+		pixels.SetNRGBA(1, 0, c)
+	}
+
+	buf := new(bytes.Buffer)
+
+	if err := png.Encode(buf, pixels); err != nil {
+		return nil, err
+	}
+
+	solid, err := vips.NewImageFromBuffer(buf.Bytes())
+
+	if err != nil {
+		return nil, err
+	}
+
+	pixel, err := vips.ExtractArea(solid.Image(), 0, 0, 1, 1)
+
+	if err != nil {
+		solid.Close()
+		return nil, err
+	}
+
+	solid.SetImage(pixel)
+
+	filled, err := vips.Embed(solid.Image(), 0, 0, width, height,
+		vips.InputInt("extend", int(vips.ExtendCopy)))
+
+	if err != nil {
+		solid.Close()
+		return nil, err
+	}
+
+	solid.SetImage(filled)
+
+	return solid, nil
+}
+
+// Returns the position of the overlay within the image,
+// according the gravity & margin.
+func watermarkOffsets(
+	position Gravity,
+	margin int,
+	width int,
+	height int,
+	overlayWidth int,
+	overlayHeight int) (int, int) {
+
+	left := (width - overlayWidth) / 2
+	top := (height - overlayHeight) / 2
+
+	if position == "" {
+		position = GravitySouthEast
+	}
+
+	switch position {
+	case GravityNorth, GravityNorthEast, GravityNorthWest:
+		top = margin
+
+	case GravitySouth, GravitySouthEast, GravitySouthWest:
+		top = height - overlayHeight - margin
+	}
+
+	switch position {
+	case GravityWest, GravityNorthWest, GravitySouthWest:
+		left = margin
+
+	case GravityEast, GravityNorthEast, GravitySouthEast:
+		left = width - overlayWidth - margin
+	}
+
+	return left, top
+}
+
+// Checks the watermark settings.
+func validateWatermark(
+	name string,
+	watermark WatermarkConfig,
+	encode func(string) string) error {
+
+	if name == "" || strings.ContainsAny(name, "/,:") {
+		return errors.New(fmt.Sprintf("Invalid watermark name: '%s'", name))
+	}
+
+	if (watermark.File == "") == (watermark.Url == "") {
+		return errors.New(fmt.Sprintf(
+			"Either file or url expected for watermark '%s'", name))
+	}
+
+	if watermark.File != "" {
+		if _, err := os.Stat(watermark.File); err != nil {
+			return errors.New(fmt.Sprintf(
+				"Invalid file for watermark '%s': %s", name, err))
+		}
+	} else if !strings.HasPrefix(encode(watermark.Url), "_") {
+		return errors.New(fmt.Sprintf(
+			"URL for watermark '%s' is not from a configured group: %s",
+			name, watermark.Url))
+	}
+
+	settings := Watermark{
+		Position: Gravity(watermark.Position),
+		Margin:   watermark.Margin,
+		Opacity:  watermark.Opacity,
+		Size:     watermark.Size,
+	}
+
+	if err := settings.validate(); err != nil {
+		return errors.New(fmt.Sprintf(
+			"Invalid watermark '%s': %s", name, err))
+	}
+
+	return nil
+}
+
+// Configured watermarks, whose images are loaded once
+// (either from the local files or from their group).
+type watermarkStore struct {
+	conf    map[string]WatermarkConfig
+	encode  func(string) string
+	decode  func(string) (int, []string, error)
+	origin  *originFetcher
+	mirrors *mirrorSelector
+	loads   flightGroup
+
+	mutex  sync.Mutex
+	images map[string][]byte
+}
+
+func newWatermarkStore(
+	conf Config,
+	origin *originFetcher,
+	mirrors *mirrorSelector) *watermarkStore {
+
+	return &watermarkStore{
+		conf:    conf.Watermarks,
+		encode:  EncodeMediaUrl(conf),
+		decode:  DecodeMediaUrls(conf),
+		origin:  origin,
+		mirrors: mirrors,
+		images:  make(map[string][]byte),
+	}
+}
+
+// Resolves the image of the requested watermark,
+// and the settings not specified by the request from the configured ones.
+func (s *watermarkStore) Resolve(watermark *Watermark) error {
+	conf, ok := s.conf[watermark.Name]
+
+	if !ok {
+		return invalidOption("watermark",
+			"Unknown watermark '%s'", watermark.Name)
+	}
+
+	if watermark.Position == "" {
+		watermark.Position = Gravity(conf.Position)
+	}
+
+	if watermark.Margin == 0 && !watermark.MarginSet {
+		watermark.Margin = conf.Margin
+	}
+
+	if watermark.Opacity == 0 {
+		watermark.Opacity = conf.Opacity
+	}
+
+	if watermark.Size == 0 && !watermark.SizeSet {
+		watermark.Size = conf.Size
+	}
+
+	// ---
+
+	s.mutex.Lock()
+	img, ok := s.images[watermark.Name]
+	s.mutex.Unlock()
+
+	if !ok {
+		v, err := s.loads.Do(watermark.Name, func() (interface{}, error) {
+			return s.load(conf)
+		})
+
+		if err != nil {
+			return errors.New(fmt.Sprintf(
+				"Fails to load watermark '%s': %s", watermark.Name, err))
+		}
+
+		img = v.([]byte)
+
+		s.mutex.Lock()
+		s.images[watermark.Name] = img
+		s.mutex.Unlock()
+	}
+
+	watermark.Image = img
+
+	return nil
+}
+
+func (s *watermarkStore) load(conf WatermarkConfig) ([]byte, error) {
+	if conf.File != "" {
+		return ioutil.ReadFile(conf.File)
+	}
+
+	group, urls, err := s.decode(s.encode(conf.Url))
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.origin.Fetch(s.mirrors.Order(group, urls), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New(fmt.Sprintf(
+			"Unexpected status for '%s': %d", conf.Url, resp.StatusCode))
+	}
+
+	return resp.Body, nil
+}
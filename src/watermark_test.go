@@ -0,0 +1,179 @@
+package nuggan
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/davidbyttow/govips/pkg/vips"
+)
+
+func TestParseWatermark(t *testing.T) {
+	params, err := parseParams("watermark:logo,wmpos:north,wmmargin:5,wmopacity:0.5,wmsize:0.25")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := Watermark{
+		Name:     "logo",
+		Position: GravityNorth,
+		Margin:   5,
+		Opacity:  0.5,
+		Size:     0.25,
+
+		MarginSet: true,
+		SizeSet:   true,
+	}
+
+	if params.Watermark == nil || !reflect.DeepEqual(*params.Watermark, expected) {
+		t.Errorf("%v != %v", params.Watermark, expected)
+	}
+
+	if repr := params.String(); repr != "watermark:logo,wmmargin:5,wmopacity:0.5,wmpos:north,wmsize:0.25" {
+		t.Errorf("Unexpected representation: %s", repr)
+	}
+
+	for _, segment := range []string{
+		"wmpos:north",
+		"watermark:logo,wmpos:smart",
+		"watermark:logo,wmmargin:-1",
+		"watermark:logo,wmopacity:2",
+		"watermark:logo,wmsize:x",
+	} {
+		if _, err := parseParams(segment); err == nil {
+			t.Errorf("Invalid watermark must be refused: %s", segment)
+		}
+	}
+}
+
+func TestWatermarkOffsets(t *testing.T) {
+	for position, expected := range map[Gravity][2]int{
+		"":               {140, 70},
+		GravityNorthWest: {10, 10},
+		GravityCenter:    {75, 40},
+		GravityEast:      {140, 40},
+		GravitySouth:     {75, 70},
+	} {
+		left, top := watermarkOffsets(position, 10, 200, 100, 50, 20)
+
+		if left != expected[0] || top != expected[1] {
+			t.Errorf("%s: %d/%d != %v", position, left, top, expected)
+		}
+	}
+}
+
+func TestWatermarkMargin(t *testing.T) {
+	for margin, expected := range map[int]int{
+		10:      10,
+		49:      49,
+		50:      49,
+		1000000: 49,
+	} {
+		if got := watermarkMargin(margin, 200, 100); got != expected {
+			t.Errorf("%d: %d != %d", margin, got, expected)
+		}
+	}
+
+	if got := watermarkMargin(10, 1, 1); got != 0 {
+		t.Errorf("Margin must be capped to 0: %d", got)
+	}
+}
+
+func TestResolveWatermark(t *testing.T) {
+	file, err := ioutil.TempFile("", "watermark")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer os.Remove(file.Name())
+
+	file.Write(watermarkPng(t))
+	file.Close()
+
+	store := newWatermarkStore(Config{
+		Watermarks: map[string]WatermarkConfig{
+			"logo": {File: file.Name(), Margin: 10, Opacity: 0.5},
+		},
+	}, nil, nil)
+
+	watermark := Watermark{Name: "logo", Opacity: 0.8}
+
+	if err := store.Resolve(&watermark); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if watermark.Margin != 10 || watermark.Opacity != 0.8 || len(watermark.Image) == 0 {
+		t.Errorf("Unexpected watermark: %v", watermark)
+	}
+
+	// Explicitly without margin
+	params, err := parseParams("watermark:logo,wmmargin:0")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if repr := params.String(); repr != "watermark:logo,wmmargin:0" {
+		t.Errorf("Unexpected representation: %s", repr)
+	}
+
+	if err := store.Resolve(params.Watermark); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if params.Watermark.Margin != 0 || params.Watermark.Opacity != 0.5 {
+		t.Errorf("Unexpected watermark: %v", params.Watermark)
+	}
+
+	err = store.Resolve(&Watermark{Name: "other"})
+
+	if _, ok := err.(*InvalidOptionError); !ok {
+		t.Errorf("Unknown watermark must be refused: %v", err)
+	}
+}
+
+func TestWatermarkImage(t *testing.T) {
+	noise, err := vips.Gaussnoise(200, 100)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	img := vips.NewImageRef(noise, vips.ImageTypeJPEG)
+
+	err = WatermarkImage(img, Watermark{
+		Name:    "logo",
+		Image:   watermarkPng(t),
+		Opacity: 0.5,
+		Size:    0.2,
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if img.Width() != 200 || img.Height() != 100 || img.Bands() != 3 {
+		t.Errorf("Unexpected image: %dx%d (%d bands)",
+			img.Width(), img.Height(), img.Bands())
+	}
+
+	if err := WatermarkImage(img, Watermark{Name: "logo"}); err == nil {
+		t.Error("Watermark without image must be refused")
+	}
+}
+
+func watermarkPng(t *testing.T) []byte {
+	buf := new(bytes.Buffer)
+
+	if err := png.Encode(buf, image.NewNRGBA(image.Rect(0, 0, 40, 20))); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return buf.Bytes()
+}